@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/RobertMe/cert-watcher/pkg/config/static"
 	"github.com/RobertMe/cert-watcher/pkg/controller"
-	subscriberChain "github.com/RobertMe/cert-watcher/pkg/subscriber/chain"
-	watcherChain "github.com/RobertMe/cert-watcher/pkg/watcher/chain"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
 	"os"
 	"os/signal"
 	"syscall"
@@ -15,9 +16,18 @@ import (
 
 func main() {
 	debug := flag.Bool("debug", false, "sets log level to debug")
+	socket := flag.String("socket", "", "control socket used by commands, defaults to the configured socket")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args(), *socket))
+	}
+
 	log.Info().Msg("Start cert-watcher")
 
 	// TODO: flag
@@ -31,33 +41,103 @@ func main() {
 
 	configureLogging(*debug, config.Log)
 
-	log.Debug().Msg("Creating watchers and subscribers")
-	watchers := watcherChain.NewWatcherChain(*config.Watchers)
-	subscribers := subscriberChain.NewSubscriberChain(*config.Subscribers)
-	log.Debug().Msg("Created watchers and subscribers")
+	options := []controller.Option{
+		controller.WithLogger(log.Logger),
+		controller.WithWatchers(config.Watchers),
+		controller.WithSubscribers(config.Subscribers),
+	}
 
-	ctx := createContext()
-	ctx = log.Logger.WithContext(ctx)
+	if config.Fallback != nil {
+		options = append(options, controller.WithFallbackIssuer(config.Fallback))
+	}
+
+	if config.Selection != nil {
+		options = append(options, controller.WithSelectionPolicy(config.Selection))
+	}
+
+	if config.GracePeriod > 0 {
+		options = append(options, controller.WithGracePeriod(config.GracePeriod))
+	}
 
 	log.Debug().Msg("Creating controller")
-	ctr := controller.NewController(watchers, subscribers)
+	ctr, err := controller.New(options...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to create controller")
+		return
+	}
 	log.Debug().Msg("Created controller")
 
+	ctx := createContext()
+	ctx = log.Logger.WithContext(ctx)
+
+	if config.Control != nil {
+		if err := config.Control.Init(); err != nil {
+			log.Fatal().Err(err).Msg("Unable to initialize control socket")
+			return
+		}
+	}
+
+	if config.API != nil {
+		if err := config.API.Init(); err != nil {
+			log.Fatal().Err(err).Msg("Unable to initialize status API")
+			return
+		}
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+
 	log.Info().Msg("Starting controller")
-	ctr.Start(ctx)
-	log.Info().Msg("Started controller")
+	group.Go(func() error {
+		return ctr.Run(groupCtx)
+	})
+
+	if config.Control != nil {
+		group.Go(func() error {
+			return config.Control.Run(ctr, groupCtx)
+		})
+	}
+
+	if config.API != nil {
+		group.Go(func() error {
+			return config.API.Run(ctr, groupCtx)
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		log.Error().Err(err).Msg("Stopped cert-watcher")
+		os.Exit(exitCode(err))
+	}
 
-	ctr.Wait()
+	log.Info().Msg("Stopped cert-watcher")
 }
 
+const (
+	exitFailure = 1
+	exitTimeout = 3
+)
+
+func exitCode(err error) int {
+	if errors.Is(err, controller.ErrGracePeriodExceeded) {
+		return exitTimeout
+	}
+
+	return exitFailure
+}
+
+// createContext returns a context which is done on the first SIGINT or
+// SIGTERM, a second signal exits immediately.
 func createContext() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	signals := make(chan os.Signal)
+	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-signals
 		cancel()
+
+		<-signals
+		log.Warn().Msg("Received second stop signal, exiting immediately")
+		os.Exit(exitFailure)
 	}()
 
 	return ctx
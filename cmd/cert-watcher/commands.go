@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/api"
+	"github.com/RobertMe/cert-watcher/pkg/config/static"
+	"github.com/RobertMe/cert-watcher/pkg/control"
+	"github.com/RobertMe/cert-watcher/pkg/tracking"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: cert-watcher [flags] [command]
+
+Without command cert-watcher is started. Commands talk to a running instance
+over its control socket:
+
+  status                      list tracked certificates and subscribers
+  deliver <domain|container>  deliver the certificates again
+  explain <container>         show the parsed labels and chosen certificates
+  healthcheck [live|ready]    exit with 0 when healthy or ready, 1 otherwise,
+                              uses the status API when configured
+`
+
+func runCommand(args []string, socket string) int {
+	config, err := static.ReadConfiguration("")
+	if err != nil {
+		config = static.NewConfiguration()
+	}
+
+	useSocket := socket != "" || config.API == nil
+	if socket == "" && config.Control != nil {
+		socket = config.Control.Socket
+	}
+
+	client := control.NewClient(socket)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	switch {
+	case args[0] == "healthcheck" && len(args) <= 2:
+		check := "live"
+		if len(args) == 2 {
+			check = args[1]
+		}
+
+		var server *api.Server
+		if !useSocket {
+			server = config.API
+			if err = server.Init(); err != nil {
+				break
+			}
+		}
+
+		err = healthcheck(ctx, client, server, check, os.Stdout)
+	case args[0] == "status" && len(args) == 1:
+		err = status(ctx, client, os.Stdout)
+	case args[0] == "deliver" && len(args) == 2:
+		err = deliver(ctx, client, args[1], os.Stdout)
+	case args[0] == "explain" && len(args) == 2:
+		err = explain(ctx, client, args[1], os.Stdout)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cert-watcher %s: %s\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+func healthcheck(ctx context.Context, client *control.Client, server *api.Server, check string, out io.Writer) error {
+	var path string
+	switch check {
+	case "live":
+		path = "/healthz"
+	case "ready":
+		path = "/readyz"
+	default:
+		return fmt.Errorf("unknown check %s, expected live or ready", check)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var err error
+	if server != nil {
+		err = checkAPI(ctx, server.Address, path)
+	} else {
+		err = client.Check(ctx, path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(out, "ok")
+	return err
+}
+
+// checkAPI requests the check from the status API, listening on all
+// interfaces is checked through the loopback interface.
+func checkAPI(ctx context.Context, address string, path string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(host, port), Path: path}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+func status(ctx context.Context, client *control.Client, out io.Writer) error {
+	snapshot, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	members, err := client.Members(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "MEMBER\tSTATE\tSINCE\tRESTARTS\tLAST ERROR")
+	for _, m := range members {
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%d\t%s\n", m.Kind, m.Name, m.State, m.Since.Format(time.RFC3339), m.Restarts, m.LastError)
+	}
+
+	fmt.Fprintln(w, "\nCERTIFICATE\tSOURCE\tKEY TYPE\tISSUER\tEXPIRES")
+	for _, c := range snapshot.Certificates {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", strings.Join(c.Names, ","), c.Source, c.KeyType, c.Issuer, c.NotAfter.Format(time.RFC3339))
+	}
+
+	fmt.Fprintln(w, "\nDOMAIN\tKEY TYPE\tSOURCE\tEXPIRES\tSUBSCRIBERS")
+	for _, d := range snapshot.Domains {
+		subscribers := strings.Join(d.Subscribers, ",")
+		if len(d.Certificates) == 0 {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t%s\n", d.Domain, subscribers)
+		}
+
+		for _, c := range d.Certificates {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.Domain, c.KeyType, selectedSource(c), c.NotAfter.Format(time.RFC3339), subscribers)
+		}
+	}
+
+	fmt.Fprintln(w, "\nSUBSCRIBER\tDOMAIN\tKEY TYPE\tACTION\tSTARTED\tRESULT")
+	for _, d := range snapshot.Deliveries {
+		result := "succeeded"
+		if d.Pending() {
+			result = "pending"
+		} else if d.Error != "" {
+			result = "failed: " + d.Error
+		}
+
+		name := d.Subscriber
+		if d.Target != "" {
+			name += "/" + d.Target
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, d.Domain, d.KeyType, d.Action, d.Started.Format(time.RFC3339), result)
+	}
+
+	return w.Flush()
+}
+
+func deliver(ctx context.Context, client *control.Client, target string, out io.Writer) error {
+	invocations, err := client.Deliver(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "Invoked %d subscriptions for %s\n", invocations, target)
+	return err
+}
+
+func explain(ctx context.Context, client *control.Client, target string, out io.Writer) error {
+	explanation, err := client.Explain(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Subscriber:\t%s\n", explanation.SubscriberName)
+	fmt.Fprintf(w, "Target:\t%s\n", explanation.Target)
+	fmt.Fprintf(w, "Valid:\t%t\n", explanation.Valid)
+	fmt.Fprintf(w, "Domains:\t%s\n", strings.Join(explanation.Domains, ", "))
+	fmt.Fprintf(w, "Key types:\t%s\n", strings.Join(explanation.KeyTypes, ", "))
+	fmt.Fprintf(w, "Skip chain changes:\t%t\n", explanation.SkipChainChanges)
+	fmt.Fprintf(w, "Actions:\t%s\n", strings.Join(explanation.Actions, "; "))
+	fmt.Fprintf(w, "Remove actions:\t%s\n", strings.Join(explanation.RemoveActions, "; "))
+
+	fmt.Fprintln(w, "\nLABEL\tVALUE")
+	for _, label := range sortedKeys(explanation.Configuration) {
+		fmt.Fprintf(w, "%s\t%s\n", label, explanation.Configuration[label])
+	}
+
+	fmt.Fprintln(w, "\nDOMAIN\tKEY TYPE\tCERTIFICATE\tEXPIRES\tREASON")
+	for _, choice := range explanation.Certificates {
+		requested := choice.RequestedKeyType
+		if requested == "" {
+			requested = "any"
+		}
+
+		if c := choice.Certificate; c != nil {
+			fmt.Fprintf(w, "%s\t%s\t%s %s\t%s\t%s\n", choice.Domain, requested, c.KeyType, selectedSource(*c), c.NotAfter.Format(time.RFC3339), c.Reason)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\tnone\t-\t-\n", choice.Domain, requested)
+		}
+	}
+
+	return w.Flush()
+}
+
+func selectedSource(c tracking.SelectedCertificate) string {
+	if c.Fallback {
+		return "fallback"
+	}
+
+	return c.Source
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
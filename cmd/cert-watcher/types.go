@@ -0,0 +1,18 @@
+package main
+
+// The watcher and subscriber types register themselves with the registry, so
+// they can be configured.
+import (
+	_ "github.com/RobertMe/cert-watcher/pkg/subscriber/containerd"
+	_ "github.com/RobertMe/cert-watcher/pkg/subscriber/docker"
+	_ "github.com/RobertMe/cert-watcher/pkg/subscriber/exec"
+	_ "github.com/RobertMe/cert-watcher/pkg/subscriber/plugin"
+	_ "github.com/RobertMe/cert-watcher/pkg/subscriber/podman"
+	_ "github.com/RobertMe/cert-watcher/pkg/subscriber/systemd"
+	_ "github.com/RobertMe/cert-watcher/pkg/subscriber/webhook"
+	_ "github.com/RobertMe/cert-watcher/pkg/watcher/acme"
+	_ "github.com/RobertMe/cert-watcher/pkg/watcher/plugin"
+	_ "github.com/RobertMe/cert-watcher/pkg/watcher/probe"
+	_ "github.com/RobertMe/cert-watcher/pkg/watcher/push"
+	_ "github.com/RobertMe/cert-watcher/pkg/watcher/traefik"
+)
@@ -0,0 +1,91 @@
+// Command custom-subscriber embeds cert-watcher with an in-process subscriber
+// which prints the certificates delivered for its domains. The certificates
+// are read from the acme.json file of Traefik passed as first argument, domains
+// without certificate get a self-signed certificate.
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/controller"
+	"github.com/RobertMe/cert-watcher/pkg/fallback"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/RobertMe/cert-watcher/pkg/watcher/traefik"
+	"github.com/rs/zerolog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type printSubscriber struct {
+	domains []string
+}
+
+func (s *printSubscriber) Init() error {
+	return nil
+}
+
+func (s *printSubscriber) Subscribe(subscriptionChannel chan<- subscriber.Message, ctx context.Context) error {
+	invocations := make(chan subscriber.Invocation, 10)
+
+	select {
+	case subscriptionChannel <- subscriber.Message{
+		Target:  "stdout",
+		Action:  subscriber.AddSubscriber,
+		Domains: s.domains,
+		Channel: invocations,
+	}:
+	case <-ctx.Done():
+		return nil
+	}
+
+	for {
+		select {
+		case invocation := <-invocations:
+			invocation.Report(s.print(invocation))
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *printSubscriber) print(invocation subscriber.Invocation) error {
+	if invocation.Action == subscriber.RemoveCertificate {
+		fmt.Printf("%s: certificate removed\n", invocation.Domain)
+		return nil
+	}
+
+	leaf, err := invocation.Certificate.Leaf()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %s certificate issued by %s, expires %s\n", invocation.Domain, invocation.KeyType, leaf.Issuer.CommonName, leaf.NotAfter)
+	return nil
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: custom-subscriber <acme.json>")
+		os.Exit(2)
+	}
+
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.InfoLevel).With().Timestamp().Logger()
+
+	ctr, err := controller.New(
+		controller.WithLogger(logger),
+		controller.WithWatcher("traefik", &traefik.Watcher{AcmePath: os.Args[1]}),
+		controller.WithSubscriber("printer", &printSubscriber{domains: []string{"example.com", "www.example.com"}}),
+		controller.WithFallbackIssuer(&fallback.Issuer{}),
+	)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Unable to create controller")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := ctr.Run(ctx); err != nil {
+		logger.Fatal().Err(err).Msg("Controller failed")
+	}
+}
@@ -0,0 +1,247 @@
+// Command file-plugin is a reference plugin, see package plugin for the
+// protocol. As watcher it sends the certificates of the <name>.crt and
+// <name>.key files in a directory, rescanning it every interval. As subscriber
+// it writes the certificates of its domains to <domain>.crt and <domain>.key
+// files in a directory.
+//
+//	watchers:
+//	  - type: plugin
+//	    name: files
+//	    command: [file-plugin]
+//	    config:
+//	      directory: /etc/ssl/incoming
+//	      interval: 60
+//	subscribers:
+//	  - type: plugin
+//	    name: files
+//	    command: [file-plugin]
+//	    config:
+//	      directory: /etc/ssl/outgoing
+//	      domains: [example.com]
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/plugin"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type config struct {
+	Directory string   `json:"directory"`
+	Interval  int      `json:"interval"`
+	Domains   []string `json:"domains"`
+}
+
+var (
+	encoder   = json.NewEncoder(os.Stdout)
+	writeLock sync.Mutex
+)
+
+func send(message plugin.Message) {
+	writeLock.Lock()
+	defer writeLock.Unlock()
+
+	if err := encoder.Encode(message); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to write message:", err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	messages := make(chan plugin.Message)
+	go func() {
+		defer close(messages)
+
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			var message plugin.Message
+			if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+				fmt.Fprintln(os.Stderr, "invalid message:", err)
+				continue
+			}
+
+			messages <- message
+		}
+	}()
+
+	configure, ok := <-messages
+	if !ok || configure.Type != plugin.TypeConfigure || configure.Version != plugin.Version {
+		fmt.Fprintln(os.Stderr, "expected configure message of version", plugin.Version)
+		os.Exit(1)
+	}
+
+	var conf config
+	content, err := json.Marshal(configure.Config)
+	if err == nil {
+		err = json.Unmarshal(content, &conf)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid config:", err)
+		os.Exit(1)
+	}
+
+	if conf.Directory == "" {
+		fmt.Fprintln(os.Stderr, "config requires a directory")
+		os.Exit(1)
+	}
+
+	switch configure.Kind {
+	case plugin.KindWatcher:
+		watch(conf, messages)
+	case plugin.KindSubscriber:
+		subscribe(conf, messages)
+	default:
+		fmt.Fprintln(os.Stderr, "unknown kind", configure.Kind)
+		os.Exit(1)
+	}
+}
+
+// watch sends the certificates in the directory until stdin is closed.
+func watch(conf config, messages <-chan plugin.Message) {
+	if conf.Interval <= 0 {
+		conf.Interval = 30
+	}
+
+	ticker := time.NewTicker(time.Duration(conf.Interval) * time.Second)
+	defer ticker.Stop()
+
+	previous := scan(conf.Directory, map[string]plugin.Message{})
+	send(plugin.Message{Type: plugin.TypeReady})
+
+	for {
+		select {
+		case _, ok := <-messages:
+			if !ok {
+				return
+			}
+		case <-ticker.C:
+			previous = scan(conf.Directory, previous)
+		}
+	}
+}
+
+// scan sends the certificates which changed since the previous scan and
+// removes those which were deleted.
+func scan(directory string, previous map[string]plugin.Message) map[string]plugin.Message {
+	current := map[string]plugin.Message{}
+
+	files, err := filepath.Glob(filepath.Join(directory, "*.crt"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to list certificates:", err)
+		return previous
+	}
+
+	for _, file := range files {
+		message, err := read(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "unable to read", file+":", err)
+			continue
+		}
+
+		current[file] = message
+
+		if old, ok := previous[file]; !ok || old.Cert != message.Cert || old.Key != message.Key {
+			send(message)
+		}
+	}
+
+	for file, message := range previous {
+		if _, ok := current[file]; !ok {
+			message.Action = plugin.RemoveCertificate
+			send(message)
+		}
+	}
+
+	return current
+}
+
+func read(file string) (plugin.Message, error) {
+	crt, err := ioutil.ReadFile(file)
+	if err != nil {
+		return plugin.Message{}, err
+	}
+
+	key, err := ioutil.ReadFile(strings.TrimSuffix(file, ".crt") + ".key")
+	if err != nil {
+		return plugin.Message{}, err
+	}
+
+	certificate := cert.Certificate{Cert: crt, Key: key}
+	leaf, err := certificate.Leaf()
+	if err != nil {
+		return plugin.Message{}, err
+	}
+
+	names := leaf.DNSNames
+	if len(names) == 0 {
+		names = []string{leaf.Subject.CommonName}
+	}
+
+	return plugin.Message{
+		Type:   plugin.TypeCertificate,
+		Action: plugin.UpdateCertificate,
+		Names:  names,
+		Cert:   string(crt),
+		Key:    string(key),
+	}, nil
+}
+
+// subscribe writes the delivered certificates until stdin is closed.
+func subscribe(conf config, messages <-chan plugin.Message) {
+	send(plugin.Message{Type: plugin.TypeSubscribe, Target: conf.Directory, Domains: conf.Domains})
+	send(plugin.Message{Type: plugin.TypeReady})
+
+	for message := range messages {
+		if message.Type != plugin.TypeInvoke {
+			continue
+		}
+
+		result := plugin.Message{Type: plugin.TypeResult, ID: message.ID}
+		if err := deliver(conf.Directory, message); err != nil {
+			result.Error = err.Error()
+		}
+
+		send(result)
+	}
+}
+
+func deliver(directory string, message plugin.Message) error {
+	if strings.ContainsAny(message.Domain, "/\\") || strings.HasPrefix(message.Domain, ".") {
+		return errors.New("invalid domain " + message.Domain)
+	}
+
+	name := strings.Replace(message.Domain, "*", "_", -1)
+	if message.KeyType != "" {
+		name += "." + message.KeyType
+	}
+
+	crt := filepath.Join(directory, name+".crt")
+	key := filepath.Join(directory, name+".key")
+
+	if message.Action == plugin.RemoveCertificate {
+		for _, file := range []string{crt, key} {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := ioutil.WriteFile(key, []byte(message.Key), 0600); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(crt, []byte(message.Cert), 0644)
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/health"
+	"github.com/RobertMe/cert-watcher/pkg/supervisor"
+	"github.com/RobertMe/cert-watcher/pkg/tracking"
+	"github.com/rs/zerolog/log"
+	"html/template"
+	"net"
+	"net/http"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardTemplate string
+
+// Backend provides the state exposed by the server.
+type Backend interface {
+	Snapshot(ctx context.Context) (*tracking.Snapshot, error)
+	Members() []supervisor.Status
+	Ready() error
+	Healthy() error
+}
+
+type Server struct {
+	Address string `description:"Address to serve the status API and dashboard on" json:"address" yaml:"address"`
+
+	backend   Backend
+	dashboard *template.Template
+}
+
+func (s *Server) Init() error {
+	if s.Address == "" {
+		s.Address = ":8080"
+	}
+
+	var err error
+	s.dashboard, err = template.New("dashboard").Funcs(template.FuncMap{
+		"since": func(t time.Time) string {
+			return time.Since(t).Round(time.Second).String()
+		},
+		"until": func(t time.Time) string {
+			return time.Until(t).Round(time.Hour).String()
+		},
+	}).Parse(dashboardTemplate)
+
+	return err
+}
+
+// Run serves the API until the context is done.
+func (s *Server) Run(backend Backend, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("component", "api").Logger()
+	s.backend = backend
+
+	listener, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:     s.Handler(),
+		BaseContext: func(net.Listener) context.Context { return logger.WithContext(parentCtx) },
+	}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	go func() {
+		select {
+		case <-parentCtx.Done():
+			server.Close()
+		case <-stopped:
+		}
+	}()
+
+	logger.Info().Str("address", listener.Addr().String()).Msg("Serving status API")
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/certificates", s.handleCertificates)
+	mux.HandleFunc("/api/subscribers", s.handleSubscribers)
+	mux.HandleFunc("/api/deliveries", s.handleDeliveries)
+	mux.HandleFunc("/api/members", s.handleMembers)
+	mux.Handle("/healthz", health.Handler(s.backend.Healthy))
+	mux.Handle("/readyz", health.Handler(s.backend.Ready))
+	mux.HandleFunc("/", s.handleDashboard)
+
+	return mux
+}
+
+func (s *Server) handleCertificates(rw http.ResponseWriter, req *http.Request) {
+	s.withSnapshot(rw, req, func(snapshot *tracking.Snapshot) {
+		WriteJSON(rw, req, map[string]interface{}{
+			"certificates": snapshot.Certificates,
+			"wildcards":    snapshot.Wildcards,
+			"domains":      snapshot.Domains,
+		})
+	})
+}
+
+func (s *Server) handleSubscribers(rw http.ResponseWriter, req *http.Request) {
+	s.withSnapshot(rw, req, func(snapshot *tracking.Snapshot) {
+		WriteJSON(rw, req, snapshot.Subscribers)
+	})
+}
+
+func (s *Server) handleDeliveries(rw http.ResponseWriter, req *http.Request) {
+	s.withSnapshot(rw, req, func(snapshot *tracking.Snapshot) {
+		WriteJSON(rw, req, snapshot.Deliveries)
+	})
+}
+
+func (s *Server) handleMembers(rw http.ResponseWriter, req *http.Request) {
+	if !allowGet(rw, req) {
+		return
+	}
+
+	WriteJSON(rw, req, s.backend.Members())
+}
+
+func (s *Server) handleDashboard(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(rw, req)
+		return
+	}
+
+	s.withSnapshot(rw, req, func(snapshot *tracking.Snapshot) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := s.dashboard.Execute(rw, newDashboard(snapshot, s.backend.Members())); err != nil {
+			log.Ctx(req.Context()).Error().Err(err).Msg("Failed rendering dashboard")
+		}
+	})
+}
+
+func (s *Server) withSnapshot(rw http.ResponseWriter, req *http.Request, handle func(snapshot *tracking.Snapshot)) {
+	if !allowGet(rw, req) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	defer cancel()
+
+	snapshot, err := s.backend.Snapshot(ctx)
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed taking snapshot")
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	handle(snapshot)
+}
+
+// allowGet responds with 405 to requests other than GET and HEAD.
+func allowGet(rw http.ResponseWriter, req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	return true
+}
+
+// WriteJSON writes the value as JSON response, a failure is only logged as the
+// response has already started.
+func WriteJSON(rw http.ResponseWriter, req *http.Request, value interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(value); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed writing response")
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"github.com/RobertMe/cert-watcher/pkg/supervisor"
+	"github.com/RobertMe/cert-watcher/pkg/tracking"
+	"time"
+)
+
+type dashboard struct {
+	Generated time.Time
+	Domains   []dashboardDomain
+	Members   []supervisor.Status
+}
+
+type dashboardDomain struct {
+	Domain       string
+	Certificates []tracking.SelectedCertificate
+	Consumers    []dashboardConsumer
+}
+
+type dashboardConsumer struct {
+	Name     string
+	Delivery *tracking.Delivery
+}
+
+func newDashboard(snapshot *tracking.Snapshot, members []supervisor.Status) *dashboard {
+	d := &dashboard{
+		Generated: time.Now(),
+		Members:   members,
+	}
+
+	deliveries := map[string]*tracking.Delivery{}
+	for i, delivery := range snapshot.Deliveries {
+		key := delivery.Domain + "|" + tracking.SubscriberKey(delivery.Subscriber, delivery.Target)
+		if previous, ok := deliveries[key]; !ok || delivery.Started.After(previous.Started) {
+			deliveries[key] = &snapshot.Deliveries[i]
+		}
+	}
+
+	for _, domain := range snapshot.Domains {
+		entry := dashboardDomain{
+			Domain:       domain.Domain,
+			Certificates: domain.Certificates,
+		}
+
+		for _, consumer := range domain.Subscribers {
+			entry.Consumers = append(entry.Consumers, dashboardConsumer{
+				Name:     consumer,
+				Delivery: deliveries[domain.Domain+"|"+consumer],
+			})
+		}
+
+		d.Domains = append(d.Domains, entry)
+	}
+
+	return d
+}
@@ -1,7 +1,104 @@
 package cert
 
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+)
+
 type Certificate struct {
 	Names []string
 	Cert  []byte
 	Key   []byte
 }
+
+// Fingerprints are the hex encoded SHA-256 hashes of the DER encoded leaf
+// certificate, the concatenated DER encoded chain certificates and the DER
+// encoded key. Chain is empty if the certificate has no chain, Key is empty if
+// there is no key.
+type Fingerprints struct {
+	Leaf  string `json:"leaf"`
+	Chain string `json:"chain"`
+	Key   string `json:"key"`
+}
+
+// Fingerprints calculates the fingerprints of the certificate. Contents which
+// aren't PEM encoded are hashed as is.
+func (c *Certificate) Fingerprints() Fingerprints {
+	var fingerprints Fingerprints
+
+	var chain []byte
+	rest := c.Cert
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		if fingerprints.Leaf == "" {
+			fingerprints.Leaf = fingerprint(block.Bytes)
+		} else {
+			chain = append(chain, block.Bytes...)
+		}
+	}
+
+	if fingerprints.Leaf == "" && len(c.Cert) > 0 {
+		fingerprints.Leaf = fingerprint(c.Cert)
+	}
+
+	if len(chain) > 0 {
+		fingerprints.Chain = fingerprint(chain)
+	}
+
+	if block, _ := pem.Decode(c.Key); block != nil {
+		fingerprints.Key = fingerprint(block.Bytes)
+	} else if len(c.Key) > 0 {
+		fingerprints.Key = fingerprint(c.Key)
+	}
+
+	return fingerprints
+}
+
+func fingerprint(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Leaf parses the first PEM encoded certificate, which is the certificate
+// issued for Names, with any following certificates forming the chain.
+func (c *Certificate) Leaf() (*x509.Certificate, error) {
+	rest := c.Cert
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("no PEM encoded certificate found")
+		}
+
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// KeyType returns the name of the public key algorithm of the certificate,
+// ecdsa, rsa or ed25519.
+func KeyType(certificate *x509.Certificate) string {
+	switch certificate.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		return "ecdsa"
+	case x509.RSA:
+		return "rsa"
+	case x509.Ed25519:
+		return "ed25519"
+	default:
+		return "unknown"
+	}
+}
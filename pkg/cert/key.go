@@ -0,0 +1,86 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+)
+
+// GenerateKey generates a private key of the algorithm, ec256, ec384,
+// rsa2048, rsa4096 or ed25519.
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch strings.ToLower(algorithm) {
+	case "ec256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ec384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "rsa2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, errors.New("unsupported key algorithm " + algorithm)
+	}
+}
+
+// EncodeKey PEM encodes the private key, ECDSA and RSA keys in their
+// traditional format and other keys as PKCS #8.
+func EncodeKey(key crypto.Signer) ([]byte, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+// DecodeKey parses a PEM encoded private key, in either the traditional
+// ECDSA or RSA format or as PKCS #8.
+func DecodeKey(content []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("key is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+	}
+
+	return nil, errors.New("unsupported key type " + block.Type)
+}
@@ -0,0 +1,150 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"gopkg.in/yaml.v2"
+	"strings"
+)
+
+// Instance is a configured instance of a registered watcher or subscriber
+// type. The name defaults to the type and identifies the instance in logs,
+// the status and the messages it sends.
+type Instance struct {
+	Type   string      `json:"type"`
+	Name   string      `json:"name"`
+	Config interface{} `json:"config"`
+}
+
+// Watchers are the configured watcher instances. They're configured as a list
+// of instances, e.g. [{type: traefik, name: edge, acme_path: ...}], or as a
+// map from type to configuration, e.g. {traefik: {acme_path: ...}}.
+type Watchers []Instance
+
+// Subscribers are the configured subscriber instances, configured like the
+// Watchers.
+type Subscribers []Instance
+
+func (w *Watchers) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	instances, err := unmarshalInstances(unmarshal, "watcher", func(typeName string) (func() interface{}, bool) {
+		registration, ok := watcher.Lookup(typeName)
+		return registration.Config, ok
+	}, watcher.Types)
+	if err != nil {
+		return err
+	}
+
+	*w = instances
+	return nil
+}
+
+func (s *Subscribers) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	instances, err := unmarshalInstances(unmarshal, "subscriber", func(typeName string) (func() interface{}, bool) {
+		registration, ok := subscriber.Lookup(typeName)
+		return registration.Config, ok
+	}, subscriber.Types)
+	if err != nil {
+		return err
+	}
+
+	*s = instances
+	return nil
+}
+
+type configLookup func(typeName string) (func() interface{}, bool)
+
+func unmarshalInstances(unmarshal func(interface{}) error, kind string, lookup configLookup, types func() []string) ([]Instance, error) {
+	var list []yaml.MapSlice
+	if err := unmarshal(&list); err != nil {
+		var legacy yaml.MapSlice
+		if unmarshal(&legacy) != nil {
+			return nil, err
+		}
+
+		list = fromLegacy(legacy)
+	}
+
+	instances := make([]Instance, 0, len(list))
+	names := map[string]bool{}
+	for i, item := range list {
+		instance, err := unmarshalInstance(item, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("%s %d: %w (available types: %s)", kind, i+1, err, strings.Join(types(), ", "))
+		}
+
+		if names[instance.Name] {
+			return nil, fmt.Errorf("%s %d: name %s is used more than once", kind, i+1, instance.Name)
+		}
+		names[instance.Name] = true
+
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+// fromLegacy converts the map from type to configuration into a list of
+// instances, types without configuration are disabled.
+func fromLegacy(legacy yaml.MapSlice) []yaml.MapSlice {
+	list := []yaml.MapSlice{}
+	for _, item := range legacy {
+		if item.Value == nil {
+			continue
+		}
+
+		instance := yaml.MapSlice{{Key: "type", Value: item.Key}}
+		if config, ok := item.Value.(yaml.MapSlice); ok {
+			instance = append(instance, config...)
+		}
+
+		list = append(list, instance)
+	}
+
+	return list
+}
+
+func unmarshalInstance(item yaml.MapSlice, lookup configLookup) (Instance, error) {
+	instance := Instance{}
+	config := yaml.MapSlice{}
+	for _, field := range item {
+		switch field.Key {
+		case "type":
+			instance.Type = fmt.Sprint(field.Value)
+		case "name":
+			instance.Name = fmt.Sprint(field.Value)
+		default:
+			config = append(config, field)
+		}
+	}
+
+	if instance.Type == "" {
+		return instance, errors.New("missing type")
+	}
+
+	newConfig, ok := lookup(instance.Type)
+	if !ok {
+		return instance, fmt.Errorf("unknown type %s", instance.Type)
+	}
+
+	if instance.Name == "" {
+		instance.Name = instance.Type
+	}
+
+	if strings.Contains(instance.Name, "/") {
+		return instance, fmt.Errorf("name %s contains a /", instance.Name)
+	}
+
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return instance, err
+	}
+
+	instance.Config = newConfig()
+	if err = yaml.Unmarshal(content, instance.Config); err != nil {
+		return instance, fmt.Errorf("%s: %w", instance.Name, err)
+	}
+
+	return instance, nil
+}
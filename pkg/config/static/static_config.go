@@ -1,33 +1,31 @@
 package static
 
 import (
-	"github.com/RobertMe/cert-watcher/pkg/subscriber/docker"
-	"github.com/RobertMe/cert-watcher/pkg/watcher/traefik"
+	"github.com/RobertMe/cert-watcher/pkg/api"
+	"github.com/RobertMe/cert-watcher/pkg/control"
+	"github.com/RobertMe/cert-watcher/pkg/fallback"
+	"github.com/RobertMe/cert-watcher/pkg/tracking"
+	"time"
 )
 
-type Watchers struct {
-	Traefik *traefik.Watcher `description:"Enable Traefik watcher" json:"traefik" yaml:"traefik"`
-}
-
-type Subscribers struct {
-	Docker *docker.Subscriber `description:"Enable Docker subscriber" json:"docker" yaml:"docker"`
-}
-
 type Log struct {
 	Level string `description:"Log level" json:"level" yaml:"level"`
 	Location []string `description:"One or more log locations" json:"location" yaml:"location"`
 }
 
 type Configuration struct {
-	Watchers    *Watchers    `description:"Watchers configuration" json:"watchers" yaml:"watchers"`
-	Subscribers *Subscribers `description:"Subscribers configuration" json:"subscribers" yaml:"subscribers"`
-	Log         *Log         `description:"Logging configuration" json:"log" yaml:"log"`
+	Watchers    Watchers                  `description:"Watcher instances" json:"watchers" yaml:"watchers"`
+	Subscribers Subscribers               `description:"Subscriber instances" json:"subscribers" yaml:"subscribers"`
+	Log         *Log                      `description:"Logging configuration" json:"log" yaml:"log"`
+	Fallback    *fallback.Issuer          `description:"Issue certificates for domains without certificate" json:"fallback" yaml:"fallback"`
+	Selection   *tracking.SelectionPolicy `description:"Certificate selection when multiple certificates cover a domain" json:"selection" yaml:"selection"`
+	API         *api.Server               `description:"Enable status API and dashboard" json:"api" yaml:"api"`
+	Control     *control.Server           `description:"Enable control socket for the command line client" json:"control" yaml:"control"`
+	GracePeriod time.Duration             `description:"Time running actions get to finish when stopping" json:"grace_period" yaml:"grace_period"`
 }
 
 func NewConfiguration() *Configuration {
 	return &Configuration{
-		Watchers:    &Watchers{},
-		Subscribers: &Subscribers{},
 		Log: &Log{
 			Level: "ERROR",
 		},
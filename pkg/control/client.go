@@ -0,0 +1,107 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/supervisor"
+	"github.com/RobertMe/cert-watcher/pkg/tracking"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a running instance over its control socket.
+type Client struct {
+	http *http.Client
+}
+
+func NewClient(socket string) *Client {
+	if socket == "" {
+		socket = DefaultSocket
+	}
+
+	return &Client{
+		http: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (c *Client) Status(ctx context.Context) (*tracking.Snapshot, error) {
+	var snapshot tracking.Snapshot
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &snapshot); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+func (c *Client) Members(ctx context.Context) ([]supervisor.Status, error) {
+	var members []supervisor.Status
+	if err := c.do(ctx, http.MethodGet, "/members", nil, &members); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// Deliver forces the delivery of the certificates of a domain or target and
+// returns the number of invocations.
+func (c *Client) Deliver(ctx context.Context, target string) (int, error) {
+	var response deliverResponse
+	if err := c.do(ctx, http.MethodPost, "/deliver", url.Values{"target": {target}}, &response); err != nil {
+		return 0, err
+	}
+
+	return response.Invocations, nil
+}
+
+// Check requests a health check, /healthz or /readyz, it returns the reason
+// when the check fails.
+func (c *Client) Check(ctx context.Context, path string) error {
+	return c.do(ctx, http.MethodGet, path, nil, nil)
+}
+
+func (c *Client) Explain(ctx context.Context, target string) (*tracking.Explanation, error) {
+	var explanation tracking.Explanation
+	if err := c.do(ctx, http.MethodGet, "/explain", url.Values{"target": {target}}, &explanation); err != nil {
+		return nil, err
+	}
+
+	return &explanation, nil
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, result interface{}) error {
+	u := url.URL{Scheme: "http", Host: "cert-watcher", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,165 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/api"
+	"github.com/RobertMe/cert-watcher/pkg/health"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/RobertMe/cert-watcher/pkg/supervisor"
+	"github.com/RobertMe/cert-watcher/pkg/tracking"
+	"github.com/rs/zerolog/log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const DefaultSocket = "/var/run/cert-watcher.sock"
+
+// Backend is the running instance controlled through the socket.
+type Backend interface {
+	Snapshot(ctx context.Context) (*tracking.Snapshot, error)
+	Deliver(ctx context.Context, target string) (int, error)
+	Explain(ctx context.Context, target string) (*tracking.Explanation, error)
+	Members() []supervisor.Status
+	Ready() error
+	Healthy() error
+}
+
+type Server struct {
+	Socket string `description:"Path of the unix socket used by the command line client" json:"socket" yaml:"socket"`
+
+	backend Backend
+}
+
+type deliverResponse struct {
+	Invocations int `json:"invocations"`
+}
+
+func (s *Server) Init() error {
+	if s.Socket == "" {
+		s.Socket = DefaultSocket
+	}
+
+	return nil
+}
+
+// Run listens on the socket until the context is done. A socket left behind
+// by a previous instance is replaced.
+func (s *Server) Run(backend Backend, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("component", "control").Logger()
+	s.backend = backend
+
+	if err := os.MkdirAll(filepath.Dir(s.Socket), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.Socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(s.Socket, 0660); err != nil {
+		listener.Close()
+		return err
+	}
+
+	server := &http.Server{
+		Handler:     s.handler(),
+		BaseContext: func(net.Listener) context.Context { return logger.WithContext(parentCtx) },
+	}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	go func() {
+		select {
+		case <-parentCtx.Done():
+			server.Close()
+		case <-stopped:
+		}
+	}()
+
+	logger.Info().Str("socket", s.Socket).Msg("Listening on control socket")
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/members", s.handleMembers)
+	mux.Handle("/healthz", health.Handler(s.backend.Healthy))
+	mux.Handle("/readyz", health.Handler(s.backend.Ready))
+	mux.HandleFunc("/deliver", s.handleDeliver)
+	mux.HandleFunc("/explain", s.handleExplain)
+
+	return mux
+}
+
+func (s *Server) handleStatus(rw http.ResponseWriter, req *http.Request) {
+	snapshot, err := s.backend.Snapshot(req.Context())
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	api.WriteJSON(rw, req, snapshot)
+}
+
+func (s *Server) handleMembers(rw http.ResponseWriter, req *http.Request) {
+	api.WriteJSON(rw, req, s.backend.Members())
+}
+
+func (s *Server) handleDeliver(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := req.URL.Query().Get("target")
+	log.Ctx(req.Context()).Info().Str("target", target).Msg("Forcing delivery")
+
+	invocations, err := s.backend.Deliver(req.Context(), target)
+	if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	if invocations == 0 {
+		http.Error(rw, "no subscriptions with a certificate match "+target, http.StatusNotFound)
+		return
+	}
+
+	api.WriteJSON(rw, req, deliverResponse{Invocations: invocations})
+}
+
+func (s *Server) handleExplain(rw http.ResponseWriter, req *http.Request) {
+	target := req.URL.Query().Get("target")
+
+	explanation, err := s.backend.Explain(req.Context(), target)
+	if errors.Is(err, subscriber.ErrUnknownTarget) {
+		http.Error(rw, "no subscriber knows "+target, http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(rw, req, err)
+		return
+	}
+
+	api.WriteJSON(rw, req, explanation)
+}
+
+func writeError(rw http.ResponseWriter, req *http.Request, err error) {
+	log.Ctx(req.Context()).Error().Err(err).Str("path", req.URL.Path).Msg("Failed handling control request")
+	http.Error(rw, err.Error(), http.StatusInternalServerError)
+}
@@ -3,10 +3,16 @@ package controller
 import (
 	"context"
 	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/health"
 	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	subscriberChain "github.com/RobertMe/cert-watcher/pkg/subscriber/chain"
+	"github.com/RobertMe/cert-watcher/pkg/supervisor"
 	"github.com/RobertMe/cert-watcher/pkg/tracking"
 	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	watcherChain "github.com/RobertMe/cert-watcher/pkg/watcher/chain"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
 	"time"
 )
 
@@ -15,12 +21,26 @@ type Controller struct {
 	subscriber subscriber.Subscriber
 	tracker    *tracking.Tracker
 
+	// The chains are only set by New, for the options adding to them.
+	watchers    *watcherChain.WatcherChain
+	subscribers *subscriberChain.SubscriberChain
+	logger      *zerolog.Logger
+
 	watcherChan chan watcher.Message
 	subscriberChan chan subscriber.Message
 
-	stopChannel chan bool
+	gracePeriod time.Duration
 }
 
+// ErrGracePeriodExceeded is returned by Run when the subscribers didn't finish
+// their invocations within the grace period.
+var ErrGracePeriodExceeded = errors.New("grace period exceeded while stopping")
+
+const (
+	defaultGracePeriod = 30 * time.Second
+	abortTimeout       = 5 * time.Second
+)
+
 func NewController(wtcr watcher.Watcher, subscr subscriber.Subscriber) *Controller {
 	return &Controller{
 		watcher:     wtcr,
@@ -30,85 +50,272 @@ func NewController(wtcr watcher.Watcher, subscr subscriber.Subscriber) *Controll
 		watcherChan: make(chan watcher.Message, 100),
 		subscriberChan: make(chan subscriber.Message, 100),
 
-		stopChannel: make(chan bool, 1),
+		gracePeriod: defaultGracePeriod,
 	}
 }
 
-func (c *Controller) Start(parentCtx context.Context) {
-	logger := log.Ctx(parentCtx).With().Str("component", "controller").Logger()
-	ctx := logger.WithContext(parentCtx)
-	go func() {
-		<-ctx.Done()
-		logger.Info().Msg("Received stop signal")
+// SetGracePeriod configures how long running invocations may take to finish
+// when stopping.
+func (c *Controller) SetGracePeriod(gracePeriod time.Duration) {
+	c.gracePeriod = gracePeriod
+}
+
+func (c *Controller) SetFallbackIssuer(issuer tracking.Issuer) {
+	c.tracker.SetFallbackIssuer(issuer)
+}
+
+func (c *Controller) SetSelectionPolicy(selection *tracking.SelectionPolicy) {
+	c.tracker.SetSelectionPolicy(selection)
+}
 
-		c.Stop()
-	}()
+// Snapshot returns a copy of the current state of the tracker.
+func (c *Controller) Snapshot(ctx context.Context) (*tracking.Snapshot, error) {
+	return c.tracker.Snapshot(ctx)
+}
 
-	logger.Info().Msg("Starting listeners")
-	go c.listenWatchers(ctx)
-	go c.listenSubscribers(ctx)
+// Members returns the status of the supervised watchers and subscribers.
+func (c *Controller) Members() []supervisor.Status {
+	statuses := []supervisor.Status{}
+	for _, component := range []interface{}{c.watcher, c.subscriber} {
+		if reporter, ok := component.(supervisor.Reporter); ok {
+			statuses = append(statuses, reporter.Statuses()...)
+		}
+	}
 
-	watcherContext := createLoggerContext("watcher", parentCtx)
-	subscriberContext := createLoggerContext("subscriber", parentCtx)
+	return statuses
+}
 
-	c.tracker.Start(ctx)
-	go c.watcher.Watch(c.watcherChan, watcherContext)
-	go c.subscriber.Subscribe(c.subscriberChan, subscriberContext)
+// Ready reports whether every watcher and subscriber is running and did its
+// initial work, e.g. reading certificates or listing containers.
+func (c *Controller) Ready() error {
+	return health.Combine(health.Ready(c.watcher), health.Ready(c.subscriber))
 }
 
-func (c *Controller) Stop() {
-	c.stopChannel <- true
+// Healthy reports whether every watcher and subscriber is healthy.
+func (c *Controller) Healthy() error {
+	return health.Combine(health.Healthy(c.watcher), health.Healthy(c.subscriber))
 }
 
-func (c *Controller) Wait() {
-	<-c.stopChannel
+// Deliver invokes the subscribers of a domain or target again, see
+// tracking.Tracker.Deliver.
+func (c *Controller) Deliver(ctx context.Context, target string) (int, error) {
+	return c.tracker.Deliver(ctx, target)
 }
 
-func (c *Controller) Close() {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Explain reports how the target, e.g. a container, is configured and which
+// certificates would be chosen for its domains.
+func (c *Controller) Explain(ctx context.Context, target string) (*tracking.Explanation, error) {
+	explainer, ok := c.subscriber.(subscriber.Explainer)
+	if !ok {
+		return nil, subscriber.ErrUnknownTarget
+	}
 
-	go func(ctx context.Context) {
-		<-ctx.Done()
-		if errors.Is(ctx.Err(), context.Canceled) {
-			return
-		} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			panic("Timeout while stopping cert-watcher, killing instance ✝")
+	explanation, err := explainer.Explain(target, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	choices, err := c.tracker.Choose(ctx, explanation.Domains, explanation.KeyTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracking.Explanation{
+		Explanation:  explanation,
+		Certificates: choices,
+	}, nil
+}
+
+// Run starts the watchers, subscribers and tracker and blocks until the
+// context is done or one of them fails. It then stops the watchers first, lets
+// the tracker hand the queued changes to the subscribers and waits up to the
+// grace period for the subscribers to finish their invocations.
+func (c *Controller) Run(parentCtx context.Context) error {
+	if c.logger != nil {
+		parentCtx = c.logger.WithContext(parentCtx)
+	}
+
+	logger := log.Ctx(parentCtx).With().Str("component", "controller").Logger()
+
+	// The components are stopped in order, so their contexts don't derive
+	// from the parent context.
+	baseCtx := log.Ctx(parentCtx).WithContext(context.Background())
+	runCtx, cancelRun := context.WithCancel(baseCtx)
+	defer cancelRun()
+	watcherCtx, cancelWatchers := context.WithCancel(runCtx)
+	defer cancelWatchers()
+	listenerCtx, cancelListeners := context.WithCancel(runCtx)
+	defer cancelListeners()
+	trackerCtx, cancelTracker := context.WithCancel(runCtx)
+	defer cancelTracker()
+
+	group, groupCtx := errgroup.WithContext(runCtx)
+
+	watchersDone := make(chan struct{})
+	group.Go(func() error {
+		defer close(watchersDone)
+		return c.watcher.Watch(c.watcherChan, createLoggerContext("watcher", watcherCtx))
+	})
+
+	subscribersDone := make(chan struct{})
+	group.Go(func() error {
+		defer close(subscribersDone)
+		return c.subscriber.Subscribe(c.subscriberChan, createLoggerContext("subscriber", runCtx))
+	})
+
+	listenersDone := make(chan struct{})
+	group.Go(func() error {
+		defer close(listenersDone)
+		c.listen(listenerCtx)
+		return nil
+	})
+
+	trackerDone := make(chan struct{})
+	group.Go(func() error {
+		defer close(trackerDone)
+		return c.tracker.Run(trackerCtx)
+	})
+
+	select {
+	case <-parentCtx.Done():
+		logger.Info().Msg("Received stop signal")
+	case <-groupCtx.Done():
+		logger.Error().Msg("Component failed, stopping")
+	}
+
+	graceCtx, cancelGrace := context.WithTimeout(baseCtx, c.gracePeriod)
+	defer cancelGrace()
+
+	stopped := c.stop(graceCtx, &logger, []stage{
+		{"watchers", cancelWatchers, watchersDone},
+		{"listeners", cancelListeners, listenersDone},
+		{"tracker", cancelTracker, trackerDone},
+	})
+
+	// When stopping already failed the grace period passed, so the queued
+	// invocations fail right away.
+	logger.Info().Msg("Handing queued invocations to subscribers")
+	if err := c.tracker.Flush(graceCtx); err != nil && stopped {
+		logger.Error().Err(err).Msg("Subscribers didn't take queued invocations")
+		stopped = false
+	}
+
+	if stopped {
+		logger.Info().Msg("Waiting for subscribers to finish invocations")
+		if drainer, ok := c.subscriber.(subscriber.Drainer); ok {
+			if err := drainer.Drain(graceCtx); err != nil {
+				logger.Error().Err(err).Msg("Subscribers didn't finish invocations")
+				stopped = false
+			}
 		}
-	}(ctx)
+	}
+
+	// Stopping the subscribers aborts their running invocations, they get a
+	// moment to return even when the grace period passed.
+	abortCtx, cancelAbort := context.WithTimeout(baseCtx, abortTimeout)
+	defer cancelAbort()
+
+	if !c.stop(abortCtx, &logger, []stage{{"subscribers", cancelRun, subscribersDone}}) {
+		stopped = false
+	}
+
+	if !stopped {
+		// Components ignoring their context are abandoned.
+		return ErrGracePeriodExceeded
+	}
+
+	if err := group.Wait(); err != nil {
+		return err
+	}
 
-	close(c.stopChannel)
+	logger.Info().Msg("Stopped controller")
+
+	return nil
+}
+
+type stage struct {
+	name   string
+	cancel context.CancelFunc
+	done   <-chan struct{}
+}
+
+// stop stops the stages in order, it reports false when the context is done
+// before they stopped.
+func (c *Controller) stop(ctx context.Context, logger *zerolog.Logger, stages []stage) bool {
+	for _, stage := range stages {
+		logger.Info().Str("stage", stage.name).Msg("Stopping")
+		stage.cancel()
+
+		select {
+		case <-stage.done:
+		case <-ctx.Done():
+			logger.Error().Str("stage", stage.name).Msg("Timeout while stopping")
+			return false
+		}
+	}
 
-	cancel()
+	return true
 }
 
-func (c *Controller) listenWatchers(ctx context.Context) {
+// listen forwards the messages of the watchers and subscribers to the
+// tracker. Once the context is done the queued messages are still forwarded,
+// the watchers are stopped at that point.
+func (c *Controller) listen(ctx context.Context) {
 	logger := log.Ctx(ctx)
 	for {
 		select {
 		case <-ctx.Done():
-			logger.Info().Msg("Stopping watcher listener")
+			logger.Info().Msg("Stopping listeners")
+			c.drainListeners()
 			return
 		case watcherMsg := <-c.watcherChan:
-			c.tracker.CertificateChanged(&watcherMsg.Certificate)
+			c.watcherMessage(watcherMsg)
+		case subscriberMsg := <-c.subscriberChan:
+			c.subscriberMessage(subscriberMsg, true)
 		}
 	}
 }
 
-func (c *Controller) listenSubscribers(ctx context.Context) {
-	logger := log.Ctx(ctx)
+func (c *Controller) drainListeners() {
 	for {
 		select {
-		case <-ctx.Done():
-			logger.Info().Msg("Stopping subscribers listener")
-			return
+		case watcherMsg := <-c.watcherChan:
+			c.watcherMessage(watcherMsg)
 		case subscriberMsg := <-c.subscriberChan:
-			if subscriberMsg.Action == subscriber.AddSubscriber {
-				c.tracker.AddSubscription(subscriberMsg)
-			}
+			c.subscriberMessage(subscriberMsg, false)
+		default:
+			return
 		}
 	}
 }
 
+func (c *Controller) watcherMessage(watcherMsg watcher.Message) {
+	switch watcherMsg.Action {
+	case watcher.UpdateCertificate:
+		c.tracker.CertificateChanged(watcherMsg.MonitorName, watcherMsg.Provider, &watcherMsg.Certificate)
+	case watcher.RemoveCertificate:
+		c.tracker.CertificateRemoved(watcherMsg.MonitorName, watcherMsg.Provider, &watcherMsg.Certificate)
+	case watcher.ServedCertificate:
+		c.tracker.CertificateServed(watcherMsg.Endpoint, &watcherMsg.Certificate)
+	}
+}
+
+func (c *Controller) subscriberMessage(subscriberMsg subscriber.Message, watching bool) {
+	if subscriberMsg.Action != subscriber.AddSubscriber {
+		return
+	}
+
+	c.tracker.AddSubscription(subscriberMsg)
+
+	if !watching {
+		return
+	}
+
+	if listener, ok := c.watcher.(watcher.DomainListener); ok {
+		listener.DomainsRequested(subscriberMsg.Domains)
+	}
+}
+
 func createLoggerContext(componentName string, parentCtx context.Context) context.Context {
 	parentLogger := log.Ctx(parentCtx)
 	logger := parentLogger.With().Str("component", componentName).Logger()
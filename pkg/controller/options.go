@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/config/static"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	subscriberChain "github.com/RobertMe/cert-watcher/pkg/subscriber/chain"
+	"github.com/RobertMe/cert-watcher/pkg/tracking"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	watcherChain "github.com/RobertMe/cert-watcher/pkg/watcher/chain"
+	"github.com/rs/zerolog"
+	"time"
+)
+
+// Option configures a Controller created by New.
+type Option func(c *Controller) error
+
+// New creates a controller running the watchers and subscribers added by the
+// options. Errors of the options, e.g. a watcher failing to initialize, are
+// returned instead of logged.
+func New(options ...Option) (*Controller, error) {
+	watchers := watcherChain.NewWatcherChain(nil)
+	subscribers := subscriberChain.NewSubscriberChain(nil)
+
+	c := NewController(watchers, subscribers)
+	c.watchers = watchers
+	c.subscribers = subscribers
+
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// WithWatcher adds a watcher, the name identifies it in the status and is the
+// source of its certificates.
+func WithWatcher(name string, w watcher.Watcher) Option {
+	return func(c *Controller) error {
+		return c.watchers.AddWatcher(name, w)
+	}
+}
+
+// WithSubscriber adds a subscriber, the name identifies it in the status and
+// its subscriptions.
+func WithSubscriber(name string, s subscriber.Subscriber) Option {
+	return func(c *Controller) error {
+		return c.subscribers.AddSubscriber(name, s)
+	}
+}
+
+// WithWatchers adds the configured instances of registered watcher types.
+func WithWatchers(instances static.Watchers) Option {
+	return func(c *Controller) error {
+		for _, instance := range instances {
+			if err := c.watchers.AddInstance(instance); err != nil {
+				return fmt.Errorf("watcher %s: %w", instance.Name, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithSubscribers adds the configured instances of registered subscriber
+// types.
+func WithSubscribers(instances static.Subscribers) Option {
+	return func(c *Controller) error {
+		for _, instance := range instances {
+			if err := c.subscribers.AddInstance(instance); err != nil {
+				return fmt.Errorf("subscriber %s: %w", instance.Name, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the controller and the components it runs,
+// instead of the logger of the context passed to Run.
+func WithLogger(logger zerolog.Logger) Option {
+	return func(c *Controller) error {
+		c.logger = &logger
+		return nil
+	}
+}
+
+// WithFallbackIssuer issues certificates for domains without certificate. The
+// issuer is initialized when it has an Init method, like fallback.Issuer.
+func WithFallbackIssuer(issuer tracking.Issuer) Option {
+	return func(c *Controller) error {
+		if initializer, ok := issuer.(interface{ Init() error }); ok {
+			if err := initializer.Init(); err != nil {
+				return err
+			}
+		}
+
+		c.SetFallbackIssuer(issuer)
+		return nil
+	}
+}
+
+// WithSelectionPolicy configures how a certificate is chosen when multiple
+// certificates cover a domain.
+func WithSelectionPolicy(selection *tracking.SelectionPolicy) Option {
+	return func(c *Controller) error {
+		if err := selection.Init(); err != nil {
+			return err
+		}
+
+		c.SetSelectionPolicy(selection)
+		return nil
+	}
+}
+
+// WithGracePeriod configures how long running invocations may take to finish
+// when stopping.
+func WithGracePeriod(gracePeriod time.Duration) Option {
+	return func(c *Controller) error {
+		if gracePeriod <= 0 {
+			return fmt.Errorf("grace period must be positive, got %s", gracePeriod)
+		}
+
+		c.SetGracePeriod(gracePeriod)
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+package fallback
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"io/ioutil"
+	"math/big"
+	"time"
+)
+
+type Issuer struct {
+	CACertificate string        `description:"PEM file with the CA certificate to sign with, self-signs when empty" json:"ca_certificate" yaml:"ca_certificate"`
+	CAKey         string        `description:"PEM file with the private key of the CA" json:"ca_key" yaml:"ca_key"`
+	Validity      time.Duration `description:"Validity of issued certificates" json:"validity" yaml:"validity"`
+
+	caCertificate *x509.Certificate
+	caPem         []byte
+	caKey         crypto.Signer
+}
+
+func (i *Issuer) Init() error {
+	if i.Validity == 0 {
+		i.Validity = 24 * time.Hour
+	}
+
+	if i.CACertificate == "" && i.CAKey == "" {
+		return nil
+	}
+
+	if i.CACertificate == "" || i.CAKey == "" {
+		return errors.New("both ca_certificate and ca_key are required to sign with a CA")
+	}
+
+	caPem, err := ioutil.ReadFile(i.CACertificate)
+	if err != nil {
+		return err
+	}
+
+	caCertificate := cert.Certificate{Cert: caPem}
+	if i.caCertificate, err = caCertificate.Leaf(); err != nil {
+		return err
+	}
+
+	keyPem, err := ioutil.ReadFile(i.CAKey)
+	if err != nil {
+		return err
+	}
+
+	if i.caKey, err = cert.DecodeKey(keyPem); err != nil {
+		return err
+	}
+
+	i.caPem = caPem
+
+	return nil
+}
+
+// Issue creates a certificate for the domain, signed by the configured CA or
+// self-signed if no CA is configured. The key is of the requested key type,
+// rsa, ed25519 or ecdsa, which is also used when no key type is requested.
+func (i *Issuer) Issue(domain string, keyType string) (*cert.Certificate, error) {
+	key, err := generateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature
+	if keyType == "rsa" {
+		// Clients using RSA key exchange encrypt the premaster secret with
+		// the key.
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: domain, Organization: []string{"cert-watcher"}},
+		DNSNames:              []string{domain},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(i.Validity),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	parent, signer := template, crypto.Signer(key)
+	if i.caCertificate != nil {
+		parent, signer = i.caCertificate, i.caKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPem, err := cert.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate := &cert.Certificate{
+		Names: []string{domain},
+		Cert:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:   keyPem,
+	}
+
+	if i.caPem != nil {
+		certificate.Cert = append(certificate.Cert, i.caPem...)
+	}
+
+	return certificate, nil
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "", "ecdsa":
+		return cert.GenerateKey("ec256")
+	case "rsa":
+		return cert.GenerateKey("rsa2048")
+	case "ed25519":
+		return cert.GenerateKey("ed25519")
+	default:
+		return nil, errors.New("unsupported key type " + keyType)
+	}
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ReadinessChecker is implemented by components which need time before they
+// are ready, e.g. to read their certificates for the first time. Ready returns
+// the reason when the component isn't ready yet.
+type ReadinessChecker interface {
+	Ready() error
+}
+
+// HealthChecker is implemented by components which can detect they are
+// unhealthy. Healthy returns the reason when the component isn't healthy.
+type HealthChecker interface {
+	Healthy() error
+}
+
+// Ready checks the readiness of the component, components not implementing
+// ReadinessChecker are always ready.
+func Ready(component interface{}) error {
+	if checker, ok := component.(ReadinessChecker); ok {
+		return checker.Ready()
+	}
+
+	return nil
+}
+
+// Healthy checks the health of the component, components not implementing
+// HealthChecker are always healthy.
+func Healthy(component interface{}) error {
+	if checker, ok := component.(HealthChecker); ok {
+		return checker.Healthy()
+	}
+
+	return nil
+}
+
+// Combine combines the errors into a single error, nil errors are skipped.
+func Combine(errs ...error) error {
+	var messages []string
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// Handler responds with 200 when the check passes, otherwise with 503 and the
+// reason.
+func Handler(check func() error) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.Header().Set("Cache-Control", "no-store")
+
+		if err := check(); err != nil {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			rw.Write([]byte(err.Error() + "\n"))
+			return
+		}
+
+		rw.Write([]byte("ok\n"))
+	})
+}
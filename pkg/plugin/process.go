@@ -0,0 +1,238 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const maxLineSize = 1 << 20
+
+// Command configures the plugin process, it is embedded in the configuration
+// of the plugin watcher and subscriber.
+type Command struct {
+	Command     []string               `description:"Program and arguments of the plugin" json:"command" yaml:"command"`
+	Env         map[string]string      `description:"Additional environment variables of the plugin" json:"-" yaml:"env"`
+	Config      map[string]interface{} `description:"Configuration sent to the plugin" json:"-" yaml:"config"`
+	StopTimeout time.Duration          `description:"Time the plugin gets to exit after closing its stdin" json:"stop_timeout" yaml:"stop_timeout"`
+}
+
+func (c *Command) Init() error {
+	if len(c.Command) == 0 {
+		return errors.New("plugin requires a command")
+	}
+
+	if c.StopTimeout == 0 {
+		c.StopTimeout = 10 * time.Second
+	}
+
+	return nil
+}
+
+// Process is a running plugin.
+type Process struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	timeout time.Duration
+	logger  *zerolog.Logger
+
+	sendLock sync.Mutex
+	encoder  *json.Encoder
+
+	messages   chan Message
+	stderrDone chan struct{}
+	err        error
+}
+
+// Start starts the plugin and sends it the configure message.
+func (c *Command) Start(ctx context.Context, kind string) (*Process, error) {
+	logger := log.Ctx(ctx).With().Str("plugin", c.Command[0]).Logger()
+
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	cmd.Env = os.Environ()
+	for name, value := range c.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	logger.Info().Int("pid", cmd.Process.Pid).Msg("Started plugin")
+
+	p := &Process{
+		cmd:        cmd,
+		stdin:      stdin,
+		timeout:    c.StopTimeout,
+		logger:     &logger,
+		encoder:    json.NewEncoder(stdin),
+		messages:   make(chan Message),
+		stderrDone: make(chan struct{}),
+	}
+
+	go p.logStderr(stderr)
+	go p.read(stdout)
+
+	config, err := stringKeys(c.Config)
+	if err == nil {
+		err = p.Send(Message{Type: TypeConfigure, Version: Version, Kind: kind, Config: config})
+	}
+
+	if err != nil {
+		p.Stop()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Messages returns the messages sent by the plugin, the channel is closed when
+// the plugin exited.
+func (p *Process) Messages() <-chan Message {
+	return p.messages
+}
+
+// Err returns why the plugin exited, it is only set once Messages is closed.
+func (p *Process) Err() error {
+	return p.err
+}
+
+func (p *Process) Send(message Message) error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	return p.encoder.Encode(message)
+}
+
+// Stop closes the stdin of the plugin and waits for it to exit, it is killed
+// when it doesn't exit within the stop timeout.
+func (p *Process) Stop() {
+	p.stdin.Close()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case _, ok := <-p.messages:
+			if ok {
+				continue
+			}
+		case <-timer.C:
+			p.logger.Warn().Msg("Plugin didn't exit in time, killing it")
+			p.cmd.Process.Kill()
+			for range p.messages {
+			}
+		}
+
+		return
+	}
+}
+
+func (p *Process) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var message Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			p.logger.Error().Err(err).Str("line", scanner.Text()).Msg("Invalid message from plugin")
+			continue
+		}
+
+		p.messages <- message
+	}
+
+	readErr := scanner.Err()
+	if readErr != nil {
+		// Unblock the plugin so it notices the host stopped reading.
+		io.Copy(io.Discard, stdout)
+	}
+
+	<-p.stderrDone
+	err := p.cmd.Wait()
+	switch {
+	case readErr != nil:
+		p.err = readErr
+	case err != nil:
+		p.err = err
+	default:
+		p.err = errors.New("plugin exited")
+	}
+
+	p.logger.Info().Err(err).Msg("Plugin exited")
+	close(p.messages)
+}
+
+func (p *Process) logStderr(stderr io.Reader) {
+	defer close(p.stderrDone)
+
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		p.logger.Info().Str("stderr", scanner.Text()).Msg("Plugin output")
+	}
+}
+
+// stringKeys converts the maps decoded from YAML, which have interface{}
+// keys, so the config can be encoded as JSON.
+func stringKeys(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("config key %v isn't a string", key)
+			}
+
+			var err error
+			if converted[name], err = stringKeys(item); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for name, item := range value {
+			var err error
+			if converted[name], err = stringKeys(item); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			var err error
+			if converted[i], err = stringKeys(item); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/RobertMe/cert-watcher/pkg/fallback"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// buildFilePlugin builds the reference plugin in examples/file-plugin.
+func buildFilePlugin(t *testing.T) string {
+	t.Helper()
+
+	goCommand, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not available to build the plugin")
+	}
+
+	path := filepath.Join(t.TempDir(), "file-plugin")
+	build := exec.Command(goCommand, "build", "-o", path, "github.com/RobertMe/cert-watcher/examples/file-plugin")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building plugin failed: %s\n%s", err, output)
+	}
+
+	return path
+}
+
+func startPlugin(t *testing.T, path string, kind string, config map[string]interface{}) *Process {
+	t.Helper()
+
+	command := &Command{Command: []string{path}, Config: config}
+	if err := command.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	process, err := command.Start(context.Background(), kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(process.Stop)
+
+	return process
+}
+
+func receive(t *testing.T, process *Process, messageType string) Message {
+	t.Helper()
+
+	select {
+	case message, ok := <-process.Messages():
+		if !ok {
+			t.Fatalf("plugin exited: %s", process.Err())
+		}
+
+		if message.Type != messageType {
+			t.Fatalf("received %s message, expected %s", message.Type, messageType)
+		}
+
+		return message
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no %s message received", messageType)
+		return Message{}
+	}
+}
+
+func TestFilePluginWatcher(t *testing.T) {
+	path := buildFilePlugin(t)
+	directory := t.TempDir()
+
+	issuer := &fallback.Issuer{}
+	if err := issuer.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := issuer.Issue("a.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(directory, "a.crt"), certificate.Cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(directory, "a.key"), certificate.Key, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	process := startPlugin(t, path, KindWatcher, map[string]interface{}{"directory": directory})
+
+	message := receive(t, process, TypeCertificate)
+	if message.Action != UpdateCertificate || message.Cert != string(certificate.Cert) || message.Key != string(certificate.Key) {
+		t.Errorf("unexpected certificate message %+v", message)
+	}
+
+	if len(message.Names) != 1 || message.Names[0] != "a.example.com" {
+		t.Errorf("unexpected names %v", message.Names)
+	}
+
+	receive(t, process, TypeReady)
+}
+
+func TestFilePluginSubscriber(t *testing.T) {
+	path := buildFilePlugin(t)
+	directory := t.TempDir()
+
+	process := startPlugin(t, path, KindSubscriber, map[string]interface{}{
+		"directory": directory,
+		"domains":   []string{"a.example.com"},
+	})
+
+	subscribe := receive(t, process, TypeSubscribe)
+	if subscribe.Target != directory || len(subscribe.Domains) != 1 || subscribe.Domains[0] != "a.example.com" {
+		t.Errorf("unexpected subscribe message %+v", subscribe)
+	}
+
+	receive(t, process, TypeReady)
+
+	tests := []struct {
+		message Message
+		error   bool
+	}{
+		{Message{Type: TypeInvoke, ID: 1, Action: UpdateCertificate, Domain: "a.example.com", Cert: "cert", Key: "key"}, false},
+		{Message{Type: TypeInvoke, ID: 2, Action: UpdateCertificate, Domain: "../a.example.com", Cert: "cert", Key: "key"}, true},
+	}
+
+	for _, test := range tests {
+		if err := process.Send(test.message); err != nil {
+			t.Fatal(err)
+		}
+
+		result := receive(t, process, TypeResult)
+		if result.ID != test.message.ID || (result.Error != "") != test.error {
+			t.Errorf("unexpected result %+v for %s", result, test.message.Domain)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(directory, "a.example.com.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "cert" {
+		t.Errorf("unexpected certificate %q", content)
+	}
+}
+
+func TestCommandEncoding(t *testing.T) {
+	command := &Command{
+		Command: []string{"plugin"},
+		Env:     map[string]string{"PLUGIN_TOKEN": "env-secret"},
+		Config:  map[string]interface{}{"password": "config-secret"},
+	}
+
+	config, err := json.Marshal(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(config, []byte("env-secret")) || bytes.Contains(config, []byte("config-secret")) {
+		t.Errorf("environment or configuration in encoded command %s", config)
+	}
+}
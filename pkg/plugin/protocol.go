@@ -0,0 +1,75 @@
+// Package plugin runs external programs as watchers and subscribers.
+//
+// A plugin is a child process talking JSON lines over stdin and stdout, every
+// line is a Message. Whatever the plugin writes to stderr is logged.
+//
+// After starting the plugin the host sends a configure message, holding the
+// protocol version, the kind (watcher or subscriber) and the config of the
+// instance. The plugin answers with a ready message once it is set up and then:
+//
+//   - as watcher sends certificate messages with the update_certificate or
+//     remove_certificate action, the names and the PEM encoded cert and key.
+//   - as subscriber sends subscribe messages with the target, domains and
+//     optionally key types and skip_chain_changes. The host sends invoke
+//     messages for these subscriptions, with an id, the action, target,
+//     domain, key type, names, fingerprints, cert and key, which the plugin
+//     answers with a result message with the same id and an error when it
+//     failed. The key of a removed certificate isn't sent.
+//
+// Plugins are stopped by closing their stdin, they are killed when they don't
+// exit within the stop timeout. Plugins exiting by themselves are restarted
+// with backoff, they send their certificates or subscriptions again.
+package plugin
+
+import "github.com/RobertMe/cert-watcher/pkg/cert"
+
+const Version = 1
+
+const (
+	TypeConfigure   = "configure"
+	TypeReady       = "ready"
+	TypeCertificate = "certificate"
+	TypeSubscribe   = "subscribe"
+	TypeInvoke      = "invoke"
+	TypeResult      = "result"
+)
+
+// The actions of certificate and invoke messages.
+const (
+	UpdateCertificate = "update_certificate"
+	RemoveCertificate = "remove_certificate"
+)
+
+const (
+	KindWatcher    = "watcher"
+	KindSubscriber = "subscriber"
+)
+
+// Message is a line of the protocol, the fields used depend on the type.
+type Message struct {
+	Type string `json:"type"`
+
+	// configure
+	Version int         `json:"version,omitempty"`
+	Kind    string      `json:"kind,omitempty"`
+	Config  interface{} `json:"config,omitempty"`
+
+	// certificate, invoke and result
+	ID      uint64   `json:"id,omitempty"`
+	Action  string   `json:"action,omitempty"`
+	Names   []string `json:"names,omitempty"`
+	Domain  string   `json:"domain,omitempty"`
+	KeyType string   `json:"key_type,omitempty"`
+	Cert    string   `json:"cert,omitempty"`
+	Key     string   `json:"key,omitempty"`
+	Error   string   `json:"error,omitempty"`
+
+	// invoke
+	Fingerprints *cert.Fingerprints `json:"fingerprints,omitempty"`
+
+	// subscribe and invoke
+	Target           string   `json:"target,omitempty"`
+	Domains          []string `json:"domains,omitempty"`
+	KeyTypes         []string `json:"key_types,omitempty"`
+	SkipChainChanges bool     `json:"skip_chain_changes,omitempty"`
+}
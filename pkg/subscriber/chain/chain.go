@@ -2,38 +2,73 @@ package chain
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/RobertMe/cert-watcher/pkg/config/static"
+	"github.com/RobertMe/cert-watcher/pkg/health"
 	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/RobertMe/cert-watcher/pkg/supervisor"
 	"github.com/rs/zerolog/log"
-	"reflect"
+	"golang.org/x/sync/errgroup"
 )
 
 type SubscriberChain struct {
 	Subscribers []subscriber.Subscriber
+
+	names      []string
+	supervisor *supervisor.Supervisor
 }
 
 func NewSubscriberChain(conf static.Subscribers) *SubscriberChain {
-	s := SubscriberChain{}
+	s := SubscriberChain{
+		supervisor: supervisor.New("subscriber"),
+	}
 
-	if conf.Docker != nil {
-		s.quietAddSubscriber(conf.Docker)
+	for _, instance := range conf {
+		s.quietAddSubscriber(instance)
 	}
 
 	return &s
 }
 
-func (s *SubscriberChain) quietAddSubscriber(subscriber subscriber.Subscriber) {
-	if err := s.AddSubscriber(subscriber); err != nil {
-		log.Error().Err(err).Str("subscriber", reflect.TypeOf(subscriber).String()).Msg("Failed initializing subscriber")
+func (s *SubscriberChain) quietAddSubscriber(instance static.Instance) {
+	if err := s.AddInstance(instance); err != nil {
+		log.Error().Err(err).Str("subscriber", instance.Name).Str("type", instance.Type).Msg("Failed initializing subscriber")
+	}
+}
+
+// AddInstance creates a subscriber of the registered type of the instance and
+// adds it to the chain.
+func (s *SubscriberChain) AddInstance(instance static.Instance) error {
+	registration, ok := subscriber.Lookup(instance.Type)
+	if !ok {
+		return fmt.Errorf("unknown subscriber type %s", instance.Type)
 	}
+
+	subscr, err := registration.New(instance.Config)
+	if err != nil {
+		return err
+	}
+
+	return s.AddSubscriber(instance.Name, subscr)
 }
 
-func (s *SubscriberChain) AddSubscriber(subscriber subscriber.Subscriber) error {
+// AddSubscriber initializes the subscriber and adds it to the chain. The name
+// identifies the subscriber and is used as the subscriber name of its
+// subscriptions.
+func (s *SubscriberChain) AddSubscriber(name string, subscriber subscriber.Subscriber) error {
+	for _, existing := range s.names {
+		if existing == name {
+			return fmt.Errorf("subscriber %s already exists", name)
+		}
+	}
+
 	if err := subscriber.Init(); err != nil {
 		return err
 	}
 
 	s.Subscribers = append(s.Subscribers, subscriber)
+	s.names = append(s.names, name)
 
 	return nil
 }
@@ -43,14 +78,106 @@ func (s *SubscriberChain) Init() error {
 }
 
 func (s *SubscriberChain) Subscribe(subscriptionChannel chan<- subscriber.Message, parentCtx context.Context) error {
-	logger := log.Ctx(parentCtx).With().Str("subscriber", "chain").Logger()
+	group := errgroup.Group{}
+	for i, name := range s.names {
+		subscr, name := s.Subscribers[i], name
+		group.Go(func() error {
+			messages := make(chan subscriber.Message)
+			forwarded := make(chan struct{})
+			go func() {
+				defer close(forwarded)
+				forward(messages, subscriptionChannel, name)
+			}()
+
+			logger := log.Ctx(parentCtx).With().Str("instance", name).Logger()
+			ctx := logger.WithContext(parentCtx)
+			s.supervisor.Run(ctx, name, func(ctx context.Context) error {
+				return subscr.Subscribe(messages, ctx)
+			})
+
+			close(messages)
+			<-forwarded
+
+			return nil
+		})
+	}
+
+	return group.Wait()
+}
+
+// forward sends the subscriptions of a subscriber on with the name of its
+// instance.
+func forward(messages <-chan subscriber.Message, subscriptionChannel chan<- subscriber.Message, name string) {
+	for message := range messages {
+		message.SubscriberName = name
+		subscriptionChannel <- message
+	}
+}
+
+// Statuses returns the status of the supervised subscribers.
+func (s *SubscriberChain) Statuses() []supervisor.Status {
+	return s.supervisor.Statuses()
+}
+
+// Drain waits for every subscriber supporting it to finish the queued
+// invocations.
+func (s *SubscriberChain) Drain(ctx context.Context) error {
+	group := errgroup.Group{}
 	for _, subscr := range s.Subscribers {
-		go func(subscr subscriber.Subscriber) {
-			if err := subscr.Subscribe(subscriptionChannel, parentCtx); err != nil {
-				logger.Error().Err(err).Str("failed_subscriber", reflect.TypeOf(subscr).String()).Msg("Failed starting subscriber")
-			}
-		}(subscr)
+		if drainer, ok := subscr.(subscriber.Drainer); ok {
+			group.Go(func() error {
+				return drainer.Drain(ctx)
+			})
+		}
 	}
 
-	return nil
+	return group.Wait()
+}
+
+// Explain asks every subscriber supporting it to explain the target, the first
+// subscriber managing the target answers.
+func (s *SubscriberChain) Explain(target string, ctx context.Context) (*subscriber.Explanation, error) {
+	for i, subscr := range s.Subscribers {
+		explainer, ok := subscr.(subscriber.Explainer)
+		if !ok {
+			continue
+		}
+
+		explanation, err := explainer.Explain(target, ctx)
+		if errors.Is(err, subscriber.ErrUnknownTarget) {
+			continue
+		}
+
+		if explanation != nil {
+			explanation.SubscriberName = s.names[i]
+		}
+
+		return explanation, err
+	}
+
+	return nil, subscriber.ErrUnknownTarget
+}
+
+// Ready reports the subscribers which aren't running or not ready yet.
+func (s *SubscriberChain) Ready() error {
+	errs := []error{s.supervisor.Ready()}
+	for i, name := range s.names {
+		if err := health.Ready(s.Subscribers[i]); err != nil {
+			errs = append(errs, fmt.Errorf("subscriber/%s: %w", name, err))
+		}
+	}
+
+	return health.Combine(errs...)
+}
+
+// Healthy reports the subscribers which failed or aren't healthy.
+func (s *SubscriberChain) Healthy() error {
+	errs := []error{s.supervisor.Healthy()}
+	for i, name := range s.names {
+		if err := health.Healthy(s.Subscribers[i]); err != nil {
+			errs = append(errs, fmt.Errorf("subscriber/%s: %w", name, err))
+		}
+	}
+
+	return health.Combine(errs...)
 }
@@ -1,10 +1,9 @@
-package docker
+package container
 
 import (
 	"context"
 	"github.com/RobertMe/cert-watcher/pkg/subscriber"
 	"github.com/cenkalti/backoff/v4"
-	"github.com/docker/docker/client"
 	"github.com/rs/zerolog/log"
 	"regexp"
 	"sort"
@@ -13,13 +12,15 @@ import (
 )
 
 type action interface {
-	execute(invocation subscriber.Invocation, containerId string, client client.APIClient, ctx context.Context) error
+	execute(invocation subscriber.Invocation, containerId string, runtime Runtime, ctx context.Context) error
 }
 
-func parseActionLabels(labels map[string]string) ([]action, bool) {
+// parseActionLabels parses the actions from the labels with the given name,
+// e.g. cert-watcher.actions[0]=copy for name "actions".
+func parseActionLabels(labels map[string]string, name string) ([]action, bool) {
 	var actionsData = map[int]map[string]string{}
 	actionKeys := []int{}
-	actionMatcher := regexp.MustCompile("^cert-watcher\\.actions\\[(\\d)+\\](?:\\.(.+))?$")
+	actionMatcher := regexp.MustCompile("^cert-watcher\\." + regexp.QuoteMeta(name) + "\\[(\\d+)\\](?:\\.(.+))?$")
 	for k, v := range labels {
 		match := actionMatcher.FindStringSubmatch(k)
 		if match == nil {
@@ -47,7 +48,7 @@ func parseActionLabels(labels map[string]string) ([]action, bool) {
 	sort.Ints(actionKeys)
 
 	actions := []action{}
-	for k := range actionKeys {
+	for _, k := range actionKeys {
 		actionData := actionsData[k]
 		actionType, ok := actionData["action_type"]
 		if !ok {
@@ -83,39 +84,53 @@ func (s *Subscriber) invokeActions(msg subscriber.Invocation, ctx context.Contex
 	currentActionIndex := 0
 	container := s.registeredContainers[containerId]
 	actions := container.Actions
+	if msg.Action == subscriber.RemoveCertificate {
+		actions = container.RemoveActions
+	}
 
 	logger := log.Ctx(ctx).With().
 		Str("container_id", containerId).
 		Str("domain", msg.Domain).
+		Str("invocation", msg.Action).
 		Logger()
 
+	if len(actions) == 0 {
+		logger.Info().Msg("No actions configured for invocation")
+		msg.Report(nil)
+		return
+	}
+
 	logger.Info().Msg("Invoking actions on container")
 
+	var actionsErr error
 	operation := func() error {
-		client, err := s.createClient()
+		runtime, err := s.Connect()
 		if err != nil {
-			logger.Error().Err(err).Msg("Failed connecting to docker daemon")
+			logger.Error().Err(err).Msg("Failed connecting to container runtime")
 			return err
 		}
+		defer runtime.Close()
 
 		s.blockUpdate = append(s.blockUpdate, containerId)
 		defer func() {
 			s.unblockUpdate = append(s.unblockUpdate, containerId)
 		}()
 
+		actionsErr = nil
 		for ; currentActionIndex < len(actions); currentActionIndex++ {
 			action := actions[currentActionIndex]
 
 			actionLogger := logger.With().Interface("action", action).Logger()
 			actionCtx := actionLogger.WithContext(ctx)
 
-			err := action.execute(msg, containerId, client, actionCtx)
+			err := action.execute(msg, containerId, runtime, actionCtx)
 			if err == nil {
 				actionLogger.Debug().Msg("Successfully executed action")
 				continue
 			}
 
 			actionLogger.Error().Err(err).Msg("Failed invoking action")
+			actionsErr = err
 		}
 
 		return nil
@@ -127,10 +142,14 @@ func (s *Subscriber) invokeActions(msg subscriber.Invocation, ctx context.Contex
 
 	err := backoff.RetryNotify(
 		operation,
-		backoff.NewExponentialBackOff(),
+		backoff.WithContext(backoff.NewExponentialBackOff(), ctx),
 		notify,
 	)
 	if err != nil {
 		logger.Error().Err(err).Msg("Executing action failed permanently, not retrying")
+		msg.Report(err)
+		return
 	}
+
+	msg.Report(actionsErr)
 }
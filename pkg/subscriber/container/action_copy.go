@@ -0,0 +1,94 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/rs/zerolog/log"
+	"html/template"
+	"strings"
+)
+
+type actionCopy struct {
+	Destination      string
+	FileNameTemplate *template.Template
+	Format           string
+}
+
+// newCopyAction returns nil when the data lacks the destination, as an action
+// so the nil isn't wrapped in a non-nil interface.
+func newCopyAction(data map[string]string) action {
+	a := actionCopy{
+		Format: "PEM",
+	}
+
+	var ok bool
+	if a.Destination, ok = data["destination"]; !ok {
+		return nil
+	}
+
+	filename, ok := data["filename"]
+	if !ok {
+		filename = "{{.Domain}}.{{.Extension}}"
+	}
+
+	var err error
+	a.FileNameTemplate, err = template.New("").Parse(strings.TrimSpace(filename))
+	if err != nil {
+		return nil
+	}
+
+	if format, ok := data["format"]; ok && format == "PEM" {
+		a.Format = format
+	}
+
+	return &a
+}
+
+func (a *actionCopy) execute(invocation subscriber.Invocation, containerId string, runtime Runtime, ctx context.Context) error {
+	// The certificate of a removal is the removed one, which isn't copied
+	// again. Only exec and restart actions act on removals.
+	if invocation.Action == subscriber.RemoveCertificate {
+		log.Ctx(ctx).Debug().Msg("Skipping copy of removed certificate")
+		return nil
+	}
+
+	var files []File
+	switch a.Format {
+	case "PEM":
+		files = a.createPemFiles(invocation)
+	}
+
+	for _, file := range files {
+		if file.Name == "" {
+			return errors.New("no file name provided")
+		}
+	}
+
+	return runtime.CopyFiles(ctx, containerId, a.Destination, files)
+}
+
+func (a *actionCopy) createPemFiles(invocation subscriber.Invocation) []File {
+	certificate := invocation.Certificate
+
+	return []File{
+		{Name: a.buildFileName(invocation, "crt"), Mode: 0600, Content: certificate.Cert},
+		{Name: a.buildFileName(invocation, "key"), Mode: 0600, Content: certificate.Key},
+	}
+}
+
+func (a *actionCopy) buildFileName(invocation subscriber.Invocation, extension string) string {
+	buf := bytes.NewBuffer([]byte{})
+	err := a.FileNameTemplate.Execute(buf, map[string]string{
+		"Domain":    invocation.Domain,
+		"KeyType":   invocation.KeyType,
+		"Extension": extension,
+	})
+
+	if err != nil {
+		return ""
+	}
+
+	return buf.String()
+}
@@ -1,23 +1,22 @@
-package docker
+package container
 
 import (
 	"context"
 	"github.com/RobertMe/cert-watcher/pkg/subscriber"
-	dockertypes "github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	"regexp"
 	"sort"
 	"strconv"
 )
 
 type actionExec struct {
-	Command string
+	Command   string
 	Arguments []string
-	User string
-	WorkDir string
+	User      string
+	WorkDir   string
 }
 
-func newExecAction(data map[string]string) *actionExec {
+// newExecAction returns nil when the data lacks the command.
+func newExecAction(data map[string]string) action {
 	a := actionExec{}
 	var ok bool
 	if a.Command, ok = data["command"]; !ok {
@@ -51,22 +50,10 @@ func newExecAction(data map[string]string) *actionExec {
 	return &a
 }
 
-func (a *actionExec) execute(invocation subscriber.Invocation, containerId string, client client.APIClient, ctx context.Context) error {
-	config := dockertypes.ExecConfig{
-		Cmd: append([]string{a.Command}, a.Arguments...),
-		User: a.User,
-		WorkingDir: a.WorkDir,
-		Detach: true,
-	}
-
-	response, err := client.ContainerExecCreate(ctx, containerId, config)
-	if err != nil {
-		return err
-	}
-
-	execStartCheck := dockertypes.ExecStartCheck{
-		Detach: true,
-		Tty:    false,
-	}
-	return client.ContainerExecStart(ctx, response.ID, execStartCheck)
+func (a *actionExec) execute(invocation subscriber.Invocation, containerId string, runtime Runtime, ctx context.Context) error {
+	return runtime.Exec(ctx, containerId, ExecOptions{
+		Command: append([]string{a.Command}, a.Arguments...),
+		User:    a.User,
+		WorkDir: a.WorkDir,
+	})
 }
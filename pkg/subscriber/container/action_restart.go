@@ -1,9 +1,8 @@
-package docker
+package container
 
 import (
 	"context"
 	"github.com/RobertMe/cert-watcher/pkg/subscriber"
-	"github.com/docker/docker/client"
 	"time"
 )
 
@@ -25,6 +24,6 @@ func newRestartAction(data map[string]string) *actionRestart {
 	return &a
 }
 
-func (a *actionRestart) execute(_ subscriber.Invocation, containerId string, client client.APIClient, ctx context.Context) error {
-	return client.ContainerRestart(ctx, containerId, &a.Timeout)
+func (a *actionRestart) execute(_ subscriber.Invocation, containerId string, runtime Runtime, ctx context.Context) error {
+	return runtime.Restart(ctx, containerId, a.Timeout)
 }
@@ -0,0 +1,106 @@
+package container
+
+import (
+	"context"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"reflect"
+	"testing"
+)
+
+func TestParseActionLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		actions []string
+		ok      bool
+	}{
+		{
+			name: "ordered by index",
+			labels: map[string]string{
+				"cert-watcher.actions[10]":            "restart",
+				"cert-watcher.actions[2]":             "exec",
+				"cert-watcher.actions[2].command":     "reload",
+				"cert-watcher.actions[0]":             "copy",
+				"cert-watcher.actions[0].destination": "/certs",
+				"cert-watcher.remove_actions[1]":      "restart",
+			},
+			actions: []string{"*container.actionCopy", "*container.actionExec", "*container.actionRestart"},
+			ok:      true,
+		},
+		{
+			name:   "none",
+			labels: map[string]string{"cert-watcher.domains": "a.example.com"},
+			ok:     false,
+		},
+		{
+			name:   "missing type",
+			labels: map[string]string{"cert-watcher.actions[0].destination": "/certs"},
+			ok:     false,
+		},
+		{
+			name:   "unknown type",
+			labels: map[string]string{"cert-watcher.actions[0]": "unknown"},
+			ok:     false,
+		},
+		{
+			name:   "missing required data",
+			labels: map[string]string{"cert-watcher.actions[0]": "copy"},
+			ok:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actions, ok := parseActionLabels(test.labels, "actions")
+			if ok != test.ok {
+				t.Fatalf("parsed %t, expected %t", ok, test.ok)
+			}
+
+			var types []string
+			for _, a := range actions {
+				types = append(types, reflect.TypeOf(a).String())
+			}
+
+			if !reflect.DeepEqual(types, test.actions) {
+				t.Errorf("parsed %v, expected %v", types, test.actions)
+			}
+		})
+	}
+}
+
+// copyRuntime records the files copied into containers.
+type copyRuntime struct {
+	Runtime
+	copied []File
+}
+
+func (r *copyRuntime) CopyFiles(ctx context.Context, id string, destination string, files []File) error {
+	r.copied = append(r.copied, files...)
+	return nil
+}
+
+func TestCopyActionSkipsRemovals(t *testing.T) {
+	a := newCopyAction(map[string]string{"destination": "/certs"})
+	certificate := cert.Certificate{Cert: []byte("cert"), Key: []byte("key")}
+
+	tests := []struct {
+		action string
+		copied int
+	}{
+		{subscriber.UpdateCertificate, 2},
+		{subscriber.RemoveCertificate, 0},
+	}
+
+	for _, test := range tests {
+		runtime := &copyRuntime{}
+		invocation := subscriber.Invocation{Action: test.action, Domain: "a.example.com", Certificate: certificate}
+		if err := a.execute(invocation, "container", runtime, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(runtime.copied) != test.copied {
+			t.Errorf("%s copied %d files, expected %d", test.action, len(runtime.copied), test.copied)
+		}
+	}
+}
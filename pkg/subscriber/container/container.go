@@ -0,0 +1,222 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type configuration struct {
+	Domains          []string
+	KeyTypes         []string
+	SkipChainChanges bool
+	Actions          []action
+	RemoveActions    []action
+}
+
+// Subscriber subscribes the containers of a runtime which are configured with
+// cert-watcher.* labels and invokes their actions. Runtime is the name of the
+// runtime used in logs. Rules configure containers without labels.
+type Subscriber struct {
+	Runtime        string
+	Connect        Connector
+	UnhealthyAfter time.Duration
+	Rules          []Rule
+
+	registeredContainers map[string]configuration
+	blockUpdate          []string
+	unblockUpdate        []string
+
+	subscriptionChannel chan<- subscriber.Message
+	channel             chan subscriber.Invocation
+	drainChannel        chan chan struct{}
+
+	healthLock        sync.Mutex
+	listed            bool
+	disconnectedSince time.Time
+}
+
+func (s *Subscriber) Init() error {
+	if s.UnhealthyAfter == 0 {
+		s.UnhealthyAfter = 5 * time.Minute
+	}
+
+	for i := range s.Rules {
+		if err := s.Rules[i].init(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+
+	s.registeredContainers = map[string]configuration{}
+
+	s.channel = make(chan subscriber.Invocation, 10)
+	s.drainChannel = make(chan chan struct{})
+
+	return nil
+}
+
+func (s *Subscriber) Subscribe(subscriptionChannel chan<- subscriber.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("subscriber", s.Runtime).Logger()
+	ctxLog := logger.WithContext(parentCtx)
+	s.subscriptionChannel = subscriptionChannel
+	s.disconnected()
+
+	group, ctx := errgroup.WithContext(ctxLog)
+
+	group.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Info().Msg("Stopping subscriber")
+				return nil
+			case msg := <-s.channel:
+				s.invokeActions(msg, ctx)
+			case drained := <-s.drainChannel:
+				s.drainInvocations(ctx)
+				close(drained)
+			}
+		}
+	})
+
+	group.Go(func() error {
+		operation := func() error {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			defer s.disconnected()
+
+			runtime, err := s.Connect()
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed connecting to container runtime")
+				return err
+			}
+			defer runtime.Close()
+
+			err = s.listContainers(runtime, ctx)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed listing containers")
+				return err
+			}
+
+			s.connected()
+
+			return s.listenContainers(runtime, ctx)
+		}
+
+		notify := func(err error, time time.Duration) {
+			logger.Error().Err(err).Dur("retry_at", time).Msg("Operation failed, retying later")
+		}
+		err := backoff.RetryNotify(
+			operation,
+			backoff.WithContext(backoff.NewExponentialBackOff(), ctx),
+			notify,
+		)
+		if err != nil && ctx.Err() == nil {
+			logger.Error().Err(err).Msg("Operation failed permanently, not retrying")
+			return err
+		}
+
+		return nil
+	})
+
+	return group.Wait()
+}
+
+// Drain waits until the invocations queued when it is called are handled.
+func (s *Subscriber) Drain(ctx context.Context) error {
+	return subscriber.Drain(ctx, s.drainChannel)
+}
+
+func (s *Subscriber) drainInvocations(ctx context.Context) {
+	for {
+		select {
+		case msg := <-s.channel:
+			s.invokeActions(msg, ctx)
+		default:
+			return
+		}
+	}
+}
+
+func parseContainer(labels map[string]string) (configuration, bool) {
+	config := configuration{
+		Actions: []action{},
+	}
+	domainsLabel, ok := labels["cert-watcher.domains"]
+	if !ok {
+		return config, false
+	}
+
+	splitter := regexp.MustCompile("\\s*,(\\s*,*)*")
+	config.Domains = splitter.Split(strings.TrimSpace(domainsLabel), -1)
+
+	if len(config.Domains) == 0 {
+		return config, false
+	}
+
+	if keyTypesLabel, ok := labels["cert-watcher.key_types"]; ok {
+		for _, keyType := range splitter.Split(strings.ToLower(strings.TrimSpace(keyTypesLabel)), -1) {
+			switch keyType {
+			case "ecdsa", "rsa", "ed25519":
+				config.KeyTypes = append(config.KeyTypes, keyType)
+			default:
+				return config, false
+			}
+		}
+	}
+
+	if chainChanges, ok := labels["cert-watcher.chain_changes"]; ok {
+		enabled, err := strconv.ParseBool(strings.TrimSpace(chainChanges))
+		if err != nil {
+			return config, false
+		}
+
+		config.SkipChainChanges = !enabled
+	}
+
+	if config.Actions, ok = parseActionLabels(labels, "actions"); !ok {
+		return config, false
+	}
+
+	for label := range labels {
+		if !strings.HasPrefix(label, "cert-watcher.remove_actions[") {
+			continue
+		}
+
+		if config.RemoveActions, ok = parseActionLabels(labels, "remove_actions"); !ok {
+			return config, false
+		}
+
+		break
+	}
+
+	return config, true
+}
+
+func (s *Subscriber) addContainer(containerId string, name string, config configuration, ctx context.Context) {
+	msg := subscriber.Message{
+		SubscriberName:   s.Runtime,
+		Target:           name,
+		Action:           subscriber.AddSubscriber,
+		Domains:          config.Domains,
+		KeyTypes:         config.KeyTypes,
+		SkipChainChanges: config.SkipChainChanges,
+		UpdateData:       containerId,
+		Channel:          s.channel,
+	}
+
+	select {
+	case s.subscriptionChannel <- msg:
+	case <-ctx.Done():
+		return
+	}
+
+	s.registeredContainers[containerId] = config
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"context"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog/log"
+)
+
+func (s *Subscriber) listContainers(runtime Runtime, ctx context.Context) error {
+	logger := log.Ctx(ctx)
+	containers, err := runtime.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		labels := s.labels(container)
+		config, ok := parseContainer(labels)
+		containerLogger := logger.With().
+			Str("container", container.Name).
+			Interface("container_labels", labels).
+			Bool("ok", ok).
+			Logger()
+		if !ok {
+			containerLogger.Debug().Msg("Parsed container, no valid configuration found")
+			continue
+		}
+
+		containerLogger.Debug().
+			Interface("configuration", config).
+			Msg("Parsed container, valid configuration found")
+
+		s.addContainer(container.ID, container.Name, config, ctx)
+	}
+
+	return nil
+}
+
+func (s *Subscriber) listenContainers(runtime Runtime, ctx context.Context) error {
+	started := make(chan string)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- runtime.Events(ctx, started)
+	}()
+
+	for {
+		select {
+		case containerId := <-started:
+			s.handleStart(containerId, runtime, ctx)
+		case err := <-errChan:
+			if ctx.Err() != nil {
+				return backoff.Permanent(ctx.Err())
+			}
+
+			return err
+		}
+	}
+}
+
+func (s *Subscriber) handleStart(containerId string, runtime Runtime, ctx context.Context) {
+	logger := log.Ctx(ctx)
+	defer func(containerId string) {
+		for i, id := range s.unblockUpdate {
+			if id == containerId {
+				s.unblockUpdate = append(s.unblockUpdate[:i], s.unblockUpdate[i+1:]...)
+
+				for i, id := range s.blockUpdate {
+					if id == containerId {
+						s.blockUpdate = append(s.blockUpdate[:i], s.blockUpdate[i+1:]...)
+						break
+					}
+				}
+
+				break
+			}
+		}
+	}(containerId)
+
+	container, err := runtime.Inspect(ctx, containerId)
+	if err != nil {
+		logger.Error().Err(err).Str("container_id", containerId).Msg("Failed to introspect new container")
+		return
+	}
+
+	for _, id := range s.blockUpdate {
+		if id == container.ID {
+			return
+		}
+	}
+
+	labels := s.labels(container)
+	config, ok := parseContainer(labels)
+	containerLogger := logger.With().
+		Str("container", container.Name).
+		Interface("container_labels", labels).
+		Bool("ok", ok).
+		Logger()
+
+	if !ok {
+		containerLogger.Debug().Msg("Parsed container, no valid configuration found")
+		return
+	}
+
+	containerLogger.Debug().
+		Interface("configuration", config).
+		Msg("Parsed container, valid configuration found")
+
+	s.addContainer(container.ID, container.Name, config, ctx)
+}
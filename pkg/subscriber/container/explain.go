@@ -0,0 +1,67 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"strings"
+)
+
+// Explain inspects the container with the given name or id and reports how
+// its labels, merged with those of the matching rules, are parsed.
+func (s *Subscriber) Explain(target string, ctx context.Context) (*subscriber.Explanation, error) {
+	runtime, err := s.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.Close()
+
+	container, err := runtime.Inspect(ctx, target)
+	if errors.Is(err, ErrNotFound) {
+		return nil, subscriber.ErrUnknownTarget
+	} else if err != nil {
+		return nil, err
+	}
+
+	explanation := &subscriber.Explanation{
+		SubscriberName: s.Runtime,
+		Target:         container.Name,
+		Configuration:  map[string]string{},
+	}
+
+	labels := s.labels(container)
+	for label, value := range labels {
+		if strings.HasPrefix(label, "cert-watcher.") {
+			explanation.Configuration[label] = value
+		}
+	}
+
+	config, ok := parseContainer(labels)
+	explanation.Valid = ok
+	explanation.Domains = config.Domains
+	explanation.KeyTypes = config.KeyTypes
+	explanation.SkipChainChanges = config.SkipChainChanges
+	explanation.Actions = describeActions(config.Actions)
+	explanation.RemoveActions = describeActions(config.RemoveActions)
+
+	return explanation, nil
+}
+
+func describeActions(actions []action) []string {
+	descriptions := []string{}
+	for _, a := range actions {
+		switch a := a.(type) {
+		case *actionCopy:
+			descriptions = append(descriptions, fmt.Sprintf("copy %s files to %s", a.Format, a.Destination))
+		case *actionExec:
+			descriptions = append(descriptions, strings.TrimSpace(fmt.Sprintf("exec %s %s", a.Command, strings.Join(a.Arguments, " "))))
+		case *actionRestart:
+			descriptions = append(descriptions, fmt.Sprintf("restart with timeout %s", a.Timeout))
+		default:
+			descriptions = append(descriptions, fmt.Sprintf("%T", a))
+		}
+	}
+
+	return descriptions
+}
@@ -0,0 +1,49 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Ready reports whether the running containers were listed once.
+func (s *Subscriber) Ready() error {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+
+	if !s.listed {
+		return errors.New("containers not listed yet")
+	}
+
+	return nil
+}
+
+// Healthy reports whether the event stream of the runtime isn't broken for
+// longer than UnhealthyAfter.
+func (s *Subscriber) Healthy() error {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+
+	if !s.disconnectedSince.IsZero() && time.Since(s.disconnectedSince) > s.UnhealthyAfter {
+		return fmt.Errorf("%s event stream broken since %s", s.Runtime, s.disconnectedSince.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func (s *Subscriber) disconnected() {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+
+	if s.disconnectedSince.IsZero() {
+		s.disconnectedSince = time.Now()
+	}
+}
+
+func (s *Subscriber) connected() {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+
+	s.listed = true
+	s.disconnectedSince = time.Time{}
+}
@@ -0,0 +1,194 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const composeServiceLabel = "com.docker.compose.service"
+
+// Rule configures the containers it matches without cert-watcher.* labels, for
+// images deployed by tools which can't set labels. The configuration is
+// turned into the labels it corresponds to, e.g. the first action becomes
+// cert-watcher.actions[0]=<type> and cert-watcher.actions[0].<key>=<value>.
+//
+// The labels of the matching rules are merged in order, so later rules take
+// precedence over earlier rules, and the labels of the container take
+// precedence over all rules. The actions and remove actions are merged as a
+// whole, the actions of a rule replace those of the rules before it.
+type Rule struct {
+	Name          string              `description:"Regular expression matching the container name" json:"name" yaml:"name"`
+	Image         string              `description:"Image of the container, the tag is ignored when it isn't given" json:"image" yaml:"image"`
+	Service       string              `description:"Compose service of the container" json:"service" yaml:"service"`
+	Labels        map[string]string   `description:"Labels the container must have, an empty value matches any value" json:"labels" yaml:"labels"`
+	Domains       []string            `description:"Domains to subscribe to" json:"domains" yaml:"domains"`
+	KeyTypes      []string            `description:"Key types to request a certificate for" json:"key_types" yaml:"key_types"`
+	ChainChanges  *bool               `description:"Whether the actions are invoked when only the chain changed" json:"chain_changes" yaml:"chain_changes"`
+	Actions       []map[string]string `description:"Actions invoked on an updated certificate, the type key holds the action" json:"actions" yaml:"actions"`
+	RemoveActions []map[string]string `description:"Actions invoked on a removed certificate, the type key holds the action, copy actions are skipped" json:"remove_actions" yaml:"remove_actions"`
+
+	nameRegexp *regexp.Regexp
+	labels     map[string]string
+}
+
+func (r *Rule) init() error {
+	if r.Name == "" && r.Image == "" && r.Service == "" && len(r.Labels) == 0 {
+		return errors.New("rule requires a name, image, service or labels to match")
+	}
+
+	if r.Name != "" {
+		var err error
+		if r.nameRegexp, err = regexp.Compile(r.Name); err != nil {
+			return fmt.Errorf("invalid name: %w", err)
+		}
+	}
+
+	r.labels = map[string]string{}
+
+	if len(r.Domains) > 0 {
+		r.labels["cert-watcher.domains"] = strings.Join(r.Domains, ",")
+	}
+
+	if len(r.KeyTypes) > 0 {
+		r.labels["cert-watcher.key_types"] = strings.Join(r.KeyTypes, ",")
+	}
+
+	if r.ChainChanges != nil {
+		r.labels["cert-watcher.chain_changes"] = strconv.FormatBool(*r.ChainChanges)
+	}
+
+	if err := r.actionLabels("actions", r.Actions); err != nil {
+		return err
+	}
+
+	return r.actionLabels("remove_actions", r.RemoveActions)
+}
+
+func (r *Rule) actionLabels(name string, actions []map[string]string) error {
+	for i, data := range actions {
+		prefix := fmt.Sprintf("cert-watcher.%s[%d]", name, i)
+
+		for key, value := range data {
+			if key == "type" {
+				r.labels[prefix] = value
+			} else {
+				r.labels[prefix+"."+key] = value
+			}
+		}
+	}
+
+	if len(actions) == 0 {
+		return nil
+	}
+
+	if _, ok := parseActionLabels(r.labels, name); !ok {
+		return fmt.Errorf("invalid %s", strings.Replace(name, "_", " ", -1))
+	}
+
+	return nil
+}
+
+func (r *Rule) matches(container Container) bool {
+	if r.nameRegexp != nil && !r.nameRegexp.MatchString(container.Name) {
+		return false
+	}
+
+	if r.Image != "" && !matchesImage(r.Image, container.Image) {
+		return false
+	}
+
+	if r.Service != "" && container.Labels[composeServiceLabel] != r.Service {
+		return false
+	}
+
+	for label, value := range r.Labels {
+		if actual, ok := container.Labels[label]; !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesImage compares the image references, ignoring the default registry.
+// Without a tag or digest in the pattern any tag of the image matches.
+func matchesImage(pattern string, image string) bool {
+	pattern = normalizeImage(pattern)
+	image = normalizeImage(image)
+
+	if pattern == image {
+		return true
+	}
+
+	if strings.ContainsAny(pattern[strings.LastIndex(pattern, "/")+1:], ":@") {
+		return false
+	}
+
+	return pattern == repository(image)
+}
+
+func normalizeImage(image string) string {
+	image = strings.TrimPrefix(image, "docker.io/")
+	return strings.TrimPrefix(image, "library/")
+}
+
+// repository strips the tag and digest of the image.
+func repository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	return image
+}
+
+// labels returns the labels of the container merged with the labels of the
+// matching rules.
+func (s *Subscriber) labels(container Container) map[string]string {
+	labels := map[string]string{}
+
+	for i := range s.Rules {
+		if s.Rules[i].matches(container) {
+			mergeLabels(labels, s.Rules[i].labels)
+		}
+	}
+
+	mergeLabels(labels, container.Labels)
+
+	return labels
+}
+
+func mergeLabels(labels map[string]string, overrides map[string]string) {
+	for _, name := range []string{"actions", "remove_actions"} {
+		prefix := "cert-watcher." + name + "["
+		if !hasPrefix(overrides, prefix) {
+			continue
+		}
+
+		for label := range labels {
+			if strings.HasPrefix(label, prefix) {
+				delete(labels, label)
+			}
+		}
+	}
+
+	for label, value := range overrides {
+		labels[label] = value
+	}
+}
+
+func hasPrefix(labels map[string]string, prefix string) bool {
+	for label := range labels {
+		if strings.HasPrefix(label, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchesImage(t *testing.T) {
+	tests := []struct {
+		pattern string
+		image   string
+		match   bool
+	}{
+		{"nginx", "nginx", true},
+		{"nginx", "nginx:1.25", true},
+		{"nginx", "docker.io/library/nginx:1.25", true},
+		{"library/nginx", "nginx:latest", true},
+		{"nginx:1.25", "nginx:1.25", true},
+		{"nginx:1.25", "nginx:1.24", false},
+		{"nginx:1.25", "nginx", false},
+		{"nginx", "nginx@sha256:abc", true},
+		{"nginx@sha256:abc", "nginx@sha256:abc", true},
+		{"nginx@sha256:abc", "nginx@sha256:def", false},
+		{"nginx", "nginx-proxy", false},
+		{"nginx", "ghcr.io/nginx", false},
+		{"ghcr.io/org/app", "ghcr.io/org/app:v1", true},
+		{"ghcr.io/org/app", "docker.io/org/app:v1", false},
+		{"registry:5000/app", "registry:5000/app:v1", true},
+		{"registry:5000/app:v1", "registry:5000/app:v2", false},
+	}
+
+	for _, test := range tests {
+		if match := matchesImage(test.pattern, test.image); match != test.match {
+			t.Errorf("matchesImage(%q, %q) = %t, expected %t", test.pattern, test.image, match, test.match)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []Rule
+		container Container
+		labels    map[string]string
+	}{
+		{
+			name: "later rule takes precedence",
+			rules: []Rule{
+				{Image: "nginx", Domains: []string{"a.example.com"}, Actions: []map[string]string{{"type": "restart"}}},
+				{Name: "^web$", Domains: []string{"b.example.com"}},
+			},
+			container: Container{Name: "web", Image: "nginx:1.25"},
+			labels: map[string]string{
+				"cert-watcher.domains":    "b.example.com",
+				"cert-watcher.actions[0]": "restart",
+			},
+		},
+		{
+			name: "container labels take precedence",
+			rules: []Rule{
+				{Image: "nginx", Domains: []string{"a.example.com"}, KeyTypes: []string{"rsa"}},
+			},
+			container: Container{Image: "nginx", Labels: map[string]string{"cert-watcher.domains": "c.example.com"}},
+			labels: map[string]string{
+				"cert-watcher.domains":   "c.example.com",
+				"cert-watcher.key_types": "rsa",
+			},
+		},
+		{
+			name: "actions replaced as a whole",
+			rules: []Rule{
+				{Image: "nginx", Domains: []string{"a.example.com"}, Actions: []map[string]string{
+					{"type": "copy", "destination": "/certs"},
+					{"type": "restart"},
+				}},
+			},
+			container: Container{Image: "nginx", Labels: map[string]string{
+				"cert-watcher.actions[0]":         "exec",
+				"cert-watcher.actions[0].command": "reload",
+			}},
+			labels: map[string]string{
+				"cert-watcher.domains":            "a.example.com",
+				"cert-watcher.actions[0]":         "exec",
+				"cert-watcher.actions[0].command": "reload",
+			},
+		},
+		{
+			name: "non-matching rule ignored",
+			rules: []Rule{
+				{Service: "web", Domains: []string{"a.example.com"}},
+			},
+			container: Container{Image: "nginx", Labels: map[string]string{composeServiceLabel: "db"}},
+			labels:    map[string]string{composeServiceLabel: "db"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := range test.rules {
+				if err := test.rules[i].init(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := &Subscriber{Rules: test.rules}
+			if labels := s.labels(test.container); !reflect.DeepEqual(labels, test.labels) {
+				t.Errorf("labels %v, expected %v", labels, test.labels)
+			}
+		})
+	}
+}
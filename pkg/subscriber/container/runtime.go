@@ -0,0 +1,54 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned by a Runtime for containers which don't exist.
+var ErrNotFound = errors.New("container not found")
+
+// Container is a container of a runtime, Name is without leading slash and
+// Image is the reference the container was created from.
+type Container struct {
+	ID     string
+	Name   string
+	Image  string
+	Labels map[string]string
+}
+
+// File is copied into a container.
+type File struct {
+	Name    string
+	Mode    os.FileMode
+	Content []byte
+}
+
+// ExecOptions describe a command executed in a container.
+type ExecOptions struct {
+	Command []string
+	User    string
+	WorkDir string
+}
+
+// Runtime is a connection to a container runtime, e.g. the Docker Engine, on
+// which the subscriber discovers containers and invokes actions.
+type Runtime interface {
+	// List returns the running containers.
+	List(ctx context.Context) ([]Container, error)
+	// Inspect returns the container with the given id or name.
+	Inspect(ctx context.Context, id string) (Container, error)
+	// Events sends the ids of containers which started to the channel. It
+	// blocks until the context is done or the event stream broke.
+	Events(ctx context.Context, started chan<- string) error
+	CopyFiles(ctx context.Context, id string, destination string, files []File) error
+	// Exec starts the command in the container without waiting for it.
+	Exec(ctx context.Context, id string, options ExecOptions) error
+	Restart(ctx context.Context, id string, timeout time.Duration) error
+	Close() error
+}
+
+// Connector connects to a runtime, it is called for every connection.
+type Connector func() (Runtime, error)
@@ -0,0 +1,56 @@
+package containerd
+
+import (
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber/container"
+	"time"
+)
+
+// Subscriber subscribes containerd containers using the nerdctl CLI, which
+// provides the Docker like container model the labels are based on.
+type Subscriber struct {
+	Binary         string           `description:"nerdctl binary" json:"binary" yaml:"binary"`
+	Namespace      string           `description:"containerd namespace, the default of nerdctl when empty" json:"namespace" yaml:"namespace"`
+	Address        string           `description:"containerd socket, the default of nerdctl when empty" json:"address" yaml:"address"`
+	PollInterval   time.Duration    `description:"Interval containers are listed with to detect started containers" json:"poll_interval" yaml:"poll_interval"`
+	UnhealthyAfter time.Duration    `description:"Time without connection to containerd after which the subscriber is unhealthy" json:"unhealthy_after" yaml:"unhealthy_after"`
+	Rules          []container.Rule `description:"Rules configuring containers without labels" json:"rules" yaml:"rules"`
+
+	*container.Subscriber `json:"-" yaml:"-"`
+}
+
+func init() {
+	subscriber.Register(subscriber.Registration{
+		Type:   "containerd",
+		Config: func() interface{} { return &Subscriber{} },
+		New: func(config interface{}) (subscriber.Subscriber, error) {
+			return config.(*Subscriber), nil
+		},
+	})
+}
+
+func (s *Subscriber) Init() error {
+	if s.Binary == "" {
+		s.Binary = "nerdctl"
+	}
+
+	if s.PollInterval == 0 {
+		s.PollInterval = 5 * time.Second
+	}
+
+	s.Subscriber = &container.Subscriber{
+		Runtime: "containerd",
+		Connect: func() (container.Runtime, error) {
+			return &runtime{
+				binary:       s.Binary,
+				namespace:    s.Namespace,
+				address:      s.Address,
+				pollInterval: s.PollInterval,
+			}, nil
+		},
+		UnhealthyAfter: s.UnhealthyAfter,
+		Rules:          s.Rules,
+	}
+
+	return s.Subscriber.Init()
+}
@@ -0,0 +1,250 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber/container"
+	"io/ioutil"
+	"os"
+	osExec "os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runtime implements container.Runtime by running nerdctl commands.
+type runtime struct {
+	binary       string
+	namespace    string
+	address      string
+	pollInterval time.Duration
+}
+
+// inspection is the part of the output of nerdctl container inspect which is
+// used.
+type inspection struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Image  string `json:"Image"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Pid int `json:"Pid"`
+	} `json:"State"`
+}
+
+func (r *runtime) run(ctx context.Context, args ...string) ([]byte, error) {
+	var global []string
+	if r.namespace != "" {
+		global = append(global, "--namespace", r.namespace)
+	}
+
+	if r.address != "" {
+		global = append(global, "--address", r.address)
+	}
+
+	cmd := osExec.CommandContext(ctx, r.binary, append(global, args...)...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if strings.Contains(strings.ToLower(message), "no such container") {
+			return nil, container.ErrNotFound
+		}
+
+		if message == "" {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%s %s: %w: %s", r.binary, args[0], err, message)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (r *runtime) inspect(ctx context.Context, ids ...string) ([]inspection, error) {
+	output, err := r.run(ctx, append([]string{"container", "inspect", "--mode", "dockercompat"}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var inspections []inspection
+	if err := json.Unmarshal(output, &inspections); err != nil {
+		return nil, err
+	}
+
+	return inspections, nil
+}
+
+func (r *runtime) list(ctx context.Context) ([]inspection, error) {
+	output, err := r.run(ctx, "ps", "--quiet", "--no-trunc")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := strings.Fields(string(output))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	inspections, err := r.inspect(ctx, ids...)
+	if !errors.Is(err, container.ErrNotFound) {
+		return inspections, err
+	}
+
+	// A container exited after it was listed, the others are inspected one by
+	// one to leave it out.
+	inspections = nil
+	for _, id := range ids {
+		inspection, err := r.inspect(ctx, id)
+		if errors.Is(err, container.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		inspections = append(inspections, inspection...)
+	}
+
+	return inspections, nil
+}
+
+func (r *runtime) List(ctx context.Context) ([]container.Container, error) {
+	inspections, err := r.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]container.Container, 0, len(inspections))
+	for _, i := range inspections {
+		containers = append(containers, i.container())
+	}
+
+	return containers, nil
+}
+
+func (r *runtime) Inspect(ctx context.Context, id string) (container.Container, error) {
+	inspections, err := r.inspect(ctx, id)
+	if err != nil {
+		return container.Container{}, err
+	}
+
+	if len(inspections) == 0 {
+		return container.Container{}, container.ErrNotFound
+	}
+
+	return inspections[0].container(), nil
+}
+
+// Events polls the running containers, a container started when it wasn't
+// running before or its process changed, i.e. it was restarted in between.
+func (r *runtime) Events(ctx context.Context, started chan<- string) error {
+	pids := map[string]int{}
+	inspections, err := r.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range inspections {
+		pids[i.ID] = i.State.Pid
+	}
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		inspections, err := r.list(ctx)
+		if err != nil {
+			return err
+		}
+
+		current := make(map[string]int, len(inspections))
+		for _, i := range inspections {
+			current[i.ID] = i.State.Pid
+
+			if pid, ok := pids[i.ID]; ok && pid == i.State.Pid {
+				continue
+			}
+
+			select {
+			case started <- i.ID:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		pids = current
+	}
+}
+
+func (r *runtime) CopyFiles(ctx context.Context, id string, destination string, files []container.File) error {
+	dir, err := ioutil.TempDir("", "cert-watcher-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(path, file.Content, file.Mode); err != nil {
+			return err
+		}
+	}
+
+	// The trailing /. copies the contents of the directory instead of the
+	// directory itself.
+	_, err = r.run(ctx, "cp", dir+string(filepath.Separator)+".", id+":"+destination)
+	return err
+}
+
+func (r *runtime) Exec(ctx context.Context, id string, options container.ExecOptions) error {
+	args := []string{"exec", "--detach"}
+	if options.User != "" {
+		args = append(args, "--user", options.User)
+	}
+
+	if options.WorkDir != "" {
+		args = append(args, "--workdir", options.WorkDir)
+	}
+
+	args = append(append(args, id), options.Command...)
+
+	_, err := r.run(ctx, args...)
+	return err
+}
+
+func (r *runtime) Restart(ctx context.Context, id string, timeout time.Duration) error {
+	_, err := r.run(ctx, "restart", "--time", strconv.Itoa(int(timeout.Seconds())), id)
+	return err
+}
+
+func (r *runtime) Close() error {
+	return nil
+}
+
+func (i inspection) container() container.Container {
+	return container.Container{
+		ID:     i.ID,
+		Name:   strings.TrimPrefix(i.Name, "/"),
+		Image:  i.Image,
+		Labels: i.Config.Labels,
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+package containerd
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// fakeNerdctl lists the containers a and b, but b exited before it is
+// inspected.
+const fakeNerdctl = `#!/bin/sh
+case "$1" in
+ps)
+	echo a
+	echo b
+	;;
+container)
+	shift 4
+	output=""
+	for id in "$@"; do
+		if [ "$id" = b ]; then
+			echo "no such container: b" >&2
+			exit 1
+		fi
+		output="$output${output:+,}{\"Id\": \"$id\", \"Name\": \"/$id\", \"State\": {\"Pid\": 1}}"
+	done
+	echo "[$output]"
+	;;
+esac
+`
+
+func TestListSkipsExitedContainers(t *testing.T) {
+	binary := filepath.Join(t.TempDir(), "nerdctl")
+	if err := ioutil.WriteFile(binary, []byte(fakeNerdctl), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &runtime{binary: binary}
+	containers, err := r.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(containers) != 1 || containers[0].ID != "a" || containers[0].Name != "a" {
+		t.Errorf("unexpected containers %+v", containers)
+	}
+}
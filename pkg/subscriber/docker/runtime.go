@@ -0,0 +1,170 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber/container"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"strings"
+	"time"
+)
+
+// runtime implements container.Runtime using the Docker Engine API, which
+// Podman provides as well.
+type runtime struct {
+	client client.APIClient
+}
+
+// Connect connects to the Docker Engine API at the endpoint, using the given
+// API version.
+func Connect(endpoint string, timeout time.Duration, version string) (container.Runtime, error) {
+	httpHeaders := map[string]string{
+		"User-Agent": "cert-watcher",
+	}
+
+	options := []client.Opt{
+		client.WithHost(endpoint),
+		client.WithTimeout(timeout),
+		client.WithHTTPHeaders(httpHeaders),
+		client.WithVersion(version),
+	}
+
+	c, err := client.NewClientWithOpts(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runtime{client: c}, nil
+}
+
+func (r *runtime) List(ctx context.Context) ([]container.Container, error) {
+	list, err := r.client.ContainerList(ctx, dockertypes.ContainerListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]container.Container, 0, len(list))
+	for _, c := range list {
+		containers = append(containers, container.Container{
+			ID:     c.ID,
+			Name:   containerName(c.Names),
+			Image:  c.Image,
+			Labels: c.Labels,
+		})
+	}
+
+	return containers, nil
+}
+
+func (r *runtime) Inspect(ctx context.Context, id string) (container.Container, error) {
+	c, err := r.client.ContainerInspect(ctx, id)
+	if client.IsErrNotFound(err) {
+		return container.Container{}, container.ErrNotFound
+	} else if err != nil {
+		return container.Container{}, err
+	}
+
+	return container.Container{
+		ID:     c.ID,
+		Name:   strings.TrimPrefix(c.Name, "/"),
+		Image:  c.Config.Image,
+		Labels: c.Config.Labels,
+	}, nil
+}
+
+func (r *runtime) Events(ctx context.Context, started chan<- string) error {
+	f := filters.NewArgs()
+	f.Add("type", events.ContainerEventType)
+
+	eventsChan, errChan := r.client.Events(ctx, dockertypes.EventsOptions{Filters: f})
+
+	for {
+		select {
+		case event := <-eventsChan:
+			if event.Action != "start" {
+				continue
+			}
+
+			select {
+			case started <- event.ID:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case err := <-errChan:
+			return err
+		}
+	}
+}
+
+func (r *runtime) CopyFiles(ctx context.Context, id string, destination string, files []container.File) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, file := range files {
+		if err := writeBytesToTar(tw, file); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return r.client.CopyToContainer(ctx, id, destination, &buf, dockertypes.CopyToContainerOptions{})
+}
+
+func writeBytesToTar(tw *tar.Writer, file container.File) error {
+	hdr := &tar.Header{
+		Name: file.Name,
+		Mode: int64(file.Mode),
+		Size: int64(len(file.Content)),
+	}
+
+	err := tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(file.Content)
+	return err
+}
+
+func (r *runtime) Exec(ctx context.Context, id string, options container.ExecOptions) error {
+	config := dockertypes.ExecConfig{
+		Cmd:        options.Command,
+		User:       options.User,
+		WorkingDir: options.WorkDir,
+		Detach:     true,
+	}
+
+	response, err := r.client.ContainerExecCreate(ctx, id, config)
+	if err != nil {
+		return err
+	}
+
+	execStartCheck := dockertypes.ExecStartCheck{
+		Detach: true,
+		Tty:    false,
+	}
+	return r.client.ContainerExecStart(ctx, response.ID, execStartCheck)
+}
+
+func (r *runtime) Restart(ctx context.Context, id string, timeout time.Duration) error {
+	return r.client.ContainerRestart(ctx, id, &timeout)
+}
+
+func (r *runtime) Close() error {
+	return r.client.Close()
+}
+
+func containerName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(names[0], "/")
+}
@@ -0,0 +1,339 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	osExec "os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxOutput is the amount of output of the command which is kept for the log.
+const maxOutput = 4096
+
+// Subscriber runs a command on the host for every invocation of its domains.
+// The certificate is passed in temporary files, or on stdin, and its metadata
+// in CERT_WATCHER_* environment variables.
+type Subscriber struct {
+	Domains          []string      `description:"Domains to subscribe to" json:"domains" yaml:"domains"`
+	KeyTypes         []string      `description:"Key types to request a certificate for, the best certificate when empty" json:"key_types" yaml:"key_types"`
+	SkipChainChanges bool          `description:"Don't run the command when only the chain changed" json:"skip_chain_changes" yaml:"skip_chain_changes"`
+	Command          []string      `description:"Program and arguments to run" json:"command" yaml:"command"`
+	Stdin            bool          `description:"Pass the PEM encoded certificate and key on stdin instead of in files" json:"stdin" yaml:"stdin"`
+	Timeout          time.Duration `description:"Time the command may run before it is killed" json:"timeout" yaml:"timeout"`
+	Concurrency      int           `description:"Number of commands running at the same time" json:"concurrency" yaml:"concurrency"`
+	SuccessExitCodes []int         `description:"Exit codes considered successful" json:"success_exit_codes" yaml:"success_exit_codes"`
+	RetryExitCodes   []int         `description:"Exit codes after which the command is retried with backoff" json:"retry_exit_codes" yaml:"retry_exit_codes"`
+
+	channel      chan subscriber.Invocation
+	drainChannel chan chan struct{}
+}
+
+func init() {
+	subscriber.Register(subscriber.Registration{
+		Type:   "exec",
+		Config: func() interface{} { return &Subscriber{} },
+		New: func(config interface{}) (subscriber.Subscriber, error) {
+			return config.(*Subscriber), nil
+		},
+	})
+}
+
+func (s *Subscriber) Init() error {
+	if len(s.Domains) == 0 {
+		return errors.New("exec subscriber requires domains")
+	}
+
+	if len(s.Command) == 0 {
+		return errors.New("exec subscriber requires a command")
+	}
+
+	if s.Timeout == 0 {
+		s.Timeout = time.Minute
+	}
+
+	if s.Concurrency <= 0 {
+		s.Concurrency = 1
+	}
+
+	if len(s.SuccessExitCodes) == 0 {
+		s.SuccessExitCodes = []int{0}
+	}
+
+	s.channel = make(chan subscriber.Invocation, 10)
+	s.drainChannel = make(chan chan struct{})
+
+	return nil
+}
+
+func (s *Subscriber) Subscribe(subscriptionChannel chan<- subscriber.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("subscriber", "exec").Logger()
+	ctx := logger.WithContext(parentCtx)
+
+	select {
+	case subscriptionChannel <- subscriber.Message{
+		Target:           filepath.Base(s.Command[0]),
+		Action:           subscriber.AddSubscriber,
+		Domains:          s.Domains,
+		KeyTypes:         s.KeyTypes,
+		SkipChainChanges: s.SkipChainChanges,
+		Channel:          s.channel,
+	}:
+	case <-ctx.Done():
+		return nil
+	}
+
+	running := 0
+	finished := make(chan struct{})
+	var drained []chan struct{}
+
+	for {
+		if len(drained) > 0 && running == 0 && len(s.channel) == 0 {
+			for _, done := range drained {
+				close(done)
+			}
+			drained = nil
+		}
+
+		// Invocations are only taken when a command may be started.
+		var invocations <-chan subscriber.Invocation
+		if running < s.Concurrency {
+			invocations = s.channel
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info().Int("running", running).Msg("Stopping subscriber")
+			for ; running > 0; running-- {
+				<-finished
+			}
+			return nil
+		case done := <-s.drainChannel:
+			drained = append(drained, done)
+		case invocation := <-invocations:
+			running++
+			go func() {
+				invocation.Report(s.invoke(invocation, ctx))
+				finished <- struct{}{}
+			}()
+		case <-finished:
+			running--
+		}
+	}
+}
+
+// Drain waits until the commands of the invocations queued when it was called
+// finished.
+func (s *Subscriber) Drain(ctx context.Context) error {
+	return subscriber.Drain(ctx, s.drainChannel)
+}
+
+func (s *Subscriber) invoke(invocation subscriber.Invocation, ctx context.Context) error {
+	logger := log.Ctx(ctx).With().
+		Str("domain", invocation.Domain).
+		Str("key_type", invocation.KeyType).
+		Str("invocation", invocation.Action).
+		Logger()
+
+	logger.Info().Msg("Running command")
+
+	operation := func() error {
+		return s.run(invocation, &logger, ctx)
+	}
+
+	notify := func(err error, time time.Duration) {
+		logger.Error().Err(err).Dur("retry_at", time).Msg("Command failed, retrying later")
+	}
+
+	err := backoff.RetryNotify(
+		operation,
+		backoff.WithContext(backoff.NewExponentialBackOff(), ctx),
+		notify,
+	)
+	if err != nil {
+		logger.Error().Err(err).Msg("Command failed")
+		return err
+	}
+
+	logger.Info().Msg("Command succeeded")
+	return nil
+}
+
+// run runs the command once, failures are permanent unless the command exits
+// with one of the retry exit codes.
+func (s *Subscriber) run(invocation subscriber.Invocation, logger *zerolog.Logger, parentCtx context.Context) error {
+	ctx, cancel := context.WithTimeout(parentCtx, s.Timeout)
+	defer cancel()
+
+	cmd := osExec.Command(s.Command[0], s.Command[1:]...)
+	startProcessGroup(cmd)
+
+	env, err := s.environment(invocation)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	// Removals are only described in the environment, the removed key isn't
+	// handed out again.
+	if invocation.Action == subscriber.UpdateCertificate {
+		if s.Stdin {
+			stdin := append(append([]byte{}, invocation.Certificate.Cert...), invocation.Certificate.Key...)
+			cmd.Stdin = bytes.NewReader(stdin)
+		} else {
+			dir, err := writeFiles(invocation)
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+			defer os.RemoveAll(dir)
+
+			env = append(env,
+				"CERT_WATCHER_CERT_FILE="+filepath.Join(dir, "cert.pem"),
+				"CERT_WATCHER_KEY_FILE="+filepath.Join(dir, "key.pem"),
+			)
+		}
+	}
+
+	cmd.Env = append(os.Environ(), env...)
+
+	output := &limitedBuffer{limit: maxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Start(); err != nil {
+		return backoff.Permanent(err)
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-waited:
+	case <-ctx.Done():
+		// Processes started by the command keep its output open, so Wait
+		// only returns once they are killed as well.
+		if err := killProcessGroup(cmd); err != nil {
+			logger.Error().Err(err).Msg("Failed killing command")
+		}
+		err = <-waited
+	}
+
+	logger.Debug().Str("output", output.String()).Msg("Command output")
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return backoff.Permanent(fmt.Errorf("command timed out after %s", s.Timeout))
+	}
+
+	code := 0
+	if err != nil {
+		var exitErr *osExec.ExitError
+		if !errors.As(err, &exitErr) {
+			return backoff.Permanent(err)
+		}
+
+		code = exitErr.ExitCode()
+	}
+
+	if containsCode(s.SuccessExitCodes, code) {
+		return nil
+	}
+
+	err = fmt.Errorf("command exited with %d: %s", code, lastLine(output.String()))
+	if containsCode(s.RetryExitCodes, code) {
+		return err
+	}
+
+	return backoff.Permanent(err)
+}
+
+// environment describes the invocation and its certificate in environment
+// variables.
+func (s *Subscriber) environment(invocation subscriber.Invocation) ([]string, error) {
+	env := []string{
+		"CERT_WATCHER_ACTION=" + invocation.Action,
+		"CERT_WATCHER_SUBSCRIBER=" + invocation.SubscriberName,
+		"CERT_WATCHER_DOMAIN=" + invocation.Domain,
+		"CERT_WATCHER_KEY_TYPE=" + invocation.KeyType,
+		"CERT_WATCHER_NAMES=" + strings.Join(invocation.Certificate.Names, ","),
+		"CERT_WATCHER_FINGERPRINT=" + invocation.Fingerprints.Leaf,
+	}
+
+	if len(invocation.Certificate.Cert) > 0 {
+		leaf, err := invocation.Certificate.Leaf()
+		if err != nil {
+			return nil, err
+		}
+
+		env = append(env,
+			"CERT_WATCHER_NOT_BEFORE="+leaf.NotBefore.UTC().Format(time.RFC3339),
+			"CERT_WATCHER_NOT_AFTER="+leaf.NotAfter.UTC().Format(time.RFC3339),
+		)
+	}
+
+	return env, nil
+}
+
+// writeFiles writes the certificate and key to a new temporary directory,
+// which only the current user can read.
+func writeFiles(invocation subscriber.Invocation) (string, error) {
+	dir, err := ioutil.TempDir("", "cert-watcher-")
+	if err != nil {
+		return "", err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "cert.pem"), invocation.Certificate.Cert, 0600)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "key.pem"), invocation.Certificate.Key, 0600)
+	}
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return dir, nil
+}
+
+func containsCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
+
+// limitedBuffer keeps the last part of the output of the command.
+type limitedBuffer struct {
+	limit  int
+	buffer []byte
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.buffer = append(b.buffer, p...)
+	if len(b.buffer) > b.limit {
+		b.buffer = b.buffer[len(b.buffer)-b.limit:]
+	}
+
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return string(b.buffer)
+}
@@ -0,0 +1,239 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"context"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/fallback"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSubscriber(t *testing.T, s *Subscriber) *Subscriber {
+	t.Helper()
+
+	s.Domains = []string{"a.example.com"}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func newTestInvocation(t *testing.T, action string) subscriber.Invocation {
+	t.Helper()
+
+	issuer := &fallback.Issuer{Validity: 24 * time.Hour}
+	if err := issuer.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := issuer.Issue("a.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return subscriber.Invocation{
+		Action:         action,
+		SubscriberName: "exec",
+		Domain:         "a.example.com",
+		KeyType:        "ecdsa",
+		Certificate:    *certificate,
+		Fingerprints:   certificate.Fingerprints(),
+	}
+}
+
+func TestRunTimeoutKillsProcessGroup(t *testing.T) {
+	// The background sleep keeps the output open, without killing the process
+	// group the command only returns once it exits.
+	s := newTestSubscriber(t, &Subscriber{
+		Command: []string{"sh", "-c", "sleep 10 & sleep 10"},
+		Timeout: 100 * time.Millisecond,
+	})
+	logger := zerolog.Nop()
+
+	started := time.Now()
+	err := s.run(newTestInvocation(t, subscriber.UpdateCertificate), &logger, context.Background())
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("command returned after %s", elapsed)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      int
+		success   []int
+		retry     []int
+		failed    bool
+		permanent bool
+	}{
+		{name: "success", code: 0},
+		{name: "configured success", code: 3, success: []int{0, 3}},
+		{name: "failure", code: 1, failed: true, permanent: true},
+		{name: "retry", code: 4, retry: []int{4}, failed: true},
+		{name: "other than retry", code: 5, retry: []int{4}, failed: true, permanent: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestSubscriber(t, &Subscriber{
+				Command:          []string{"sh", "-c", "exit " + strconv.Itoa(test.code)},
+				SuccessExitCodes: test.success,
+				RetryExitCodes:   test.retry,
+			})
+			logger := zerolog.Nop()
+
+			err := s.run(newTestInvocation(t, subscriber.UpdateCertificate), &logger, context.Background())
+			if (err != nil) != test.failed {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			var permanent *backoff.PermanentError
+			if errors.As(err, &permanent) != test.permanent {
+				t.Errorf("permanent %t, expected %t", !test.permanent, test.permanent)
+			}
+		})
+	}
+}
+
+func TestRunEnvironment(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		files  bool
+	}{
+		{"update", subscriber.UpdateCertificate, true},
+		{"remove", subscriber.RemoveCertificate, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			script := `env | grep ^CERT_WATCHER_ > "$0/env"; ` +
+				`if [ -n "$CERT_WATCHER_CERT_FILE" ]; then cat "$CERT_WATCHER_CERT_FILE" > "$0/cert"; fi`
+			s := newTestSubscriber(t, &Subscriber{Command: []string{"sh", "-c", script, dir}})
+			logger := zerolog.Nop()
+
+			invocation := newTestInvocation(t, test.action)
+			if err := s.run(invocation, &logger, context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(dir, "env"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env := map[string]string{}
+			for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+				parts := strings.SplitN(line, "=", 2)
+				env[parts[0]] = parts[1]
+			}
+
+			expected := map[string]string{
+				"CERT_WATCHER_ACTION":      test.action,
+				"CERT_WATCHER_SUBSCRIBER":  "exec",
+				"CERT_WATCHER_DOMAIN":      "a.example.com",
+				"CERT_WATCHER_KEY_TYPE":    "ecdsa",
+				"CERT_WATCHER_NAMES":       "a.example.com",
+				"CERT_WATCHER_FINGERPRINT": invocation.Fingerprints.Leaf,
+			}
+			for name, value := range expected {
+				if env[name] != value {
+					t.Errorf("%s is %q, expected %q", name, env[name], value)
+				}
+			}
+
+			if _, ok := env["CERT_WATCHER_NOT_AFTER"]; !ok {
+				t.Error("CERT_WATCHER_NOT_AFTER not set")
+			}
+
+			if _, ok := env["CERT_WATCHER_KEY_FILE"]; ok != test.files {
+				t.Errorf("key file passed %t, expected %t", ok, test.files)
+			}
+
+			if test.files {
+				cert, err := ioutil.ReadFile(filepath.Join(dir, "cert"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if string(cert) != string(invocation.Certificate.Cert) {
+					t.Error("certificate file doesn't hold the certificate")
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	// Every command adds a file while it runs and records the number of files,
+	// which is the number of commands running at that moment.
+	script := `touch "$0/running.$$"; ls "$0" | grep -c ^running >> "$0/counts"; sleep 0.2; rm "$0/running.$$"`
+	s := newTestSubscriber(t, &Subscriber{
+		Command:     []string{"sh", "-c", script, dir},
+		Concurrency: 2,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriptions := make(chan subscriber.Message, 1)
+	go s.Subscribe(subscriptions, ctx)
+	<-subscriptions
+
+	results := make(chan subscriber.Result, 5)
+	for i := 0; i < 5; i++ {
+		invocation := newTestInvocation(t, subscriber.UpdateCertificate)
+		invocation.Results = results
+		s.channel <- invocation
+	}
+
+	for i := 0; i < 5; i++ {
+		select {
+		case result := <-results:
+			if result.Error != nil {
+				t.Error(result.Error)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d results, expected 5", i)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "counts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	highest := 0
+	for _, line := range strings.Fields(string(content)) {
+		count, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count > highest {
+			highest = count
+		}
+	}
+
+	if highest != 2 {
+		t.Errorf("at most %d commands ran at the same time, expected 2", highest)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	osExec "os/exec"
+	"syscall"
+)
+
+// startProcessGroup runs the command in its own process group, so it can be
+// killed together with the processes it started.
+func startProcessGroup(cmd *osExec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *osExec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
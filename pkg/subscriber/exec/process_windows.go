@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package exec
+
+import (
+	osExec "os/exec"
+)
+
+func startProcessGroup(cmd *osExec.Cmd) {
+}
+
+// killProcessGroup only kills the command itself, processes it started keep
+// running.
+func killProcessGroup(cmd *osExec.Cmd) error {
+	return cmd.Process.Kill()
+}
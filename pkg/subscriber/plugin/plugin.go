@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/plugin"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/rs/zerolog/log"
+	"sync/atomic"
+)
+
+// Subscriber runs an external program subscribing to certificates and
+// handling their invocations, see package plugin for the protocol.
+type Subscriber struct {
+	plugin.Command `yaml:",inline"`
+
+	channel      chan subscriber.Invocation
+	drainChannel chan chan struct{}
+	ready        int32
+}
+
+func init() {
+	subscriber.Register(subscriber.Registration{
+		Type:   "plugin",
+		Config: func() interface{} { return &Subscriber{} },
+		New: func(config interface{}) (subscriber.Subscriber, error) {
+			return config.(*Subscriber), nil
+		},
+	})
+}
+
+func (s *Subscriber) Init() error {
+	if err := s.Command.Init(); err != nil {
+		return err
+	}
+
+	s.channel = make(chan subscriber.Invocation, 10)
+	s.drainChannel = make(chan chan struct{})
+
+	return nil
+}
+
+func (s *Subscriber) Subscribe(subscriptionChannel chan<- subscriber.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("subscriber", "plugin").Logger()
+	ctx := logger.WithContext(parentCtx)
+
+	atomic.StoreInt32(&s.ready, 0)
+
+	process, err := s.Start(ctx, plugin.KindSubscriber)
+	if err != nil {
+		return err
+	}
+	defer process.Stop()
+
+	var id uint64
+	pending := map[uint64]subscriber.Invocation{}
+	var drained []chan struct{}
+
+	// Invocations sent to the plugin, but not answered, fail once it exited.
+	defer func() {
+		for _, invocation := range pending {
+			invocation.Report(errors.New("plugin exited before handling invocation"))
+		}
+	}()
+
+	for {
+		if len(drained) > 0 && len(pending) == 0 && len(s.channel) == 0 {
+			for _, done := range drained {
+				close(done)
+			}
+			drained = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Stopping subscriber")
+			return nil
+		case done := <-s.drainChannel:
+			drained = append(drained, done)
+		case invocation := <-s.channel:
+			id++
+			if err := process.Send(invokeMessage(id, invocation)); err != nil {
+				invocation.Report(err)
+				continue
+			}
+
+			pending[id] = invocation
+		case message, ok := <-process.Messages():
+			if !ok {
+				return process.Err()
+			}
+
+			switch message.Type {
+			case plugin.TypeReady:
+				atomic.StoreInt32(&s.ready, 1)
+			case plugin.TypeSubscribe:
+				select {
+				case subscriptionChannel <- subscriber.Message{
+					Target:           message.Target,
+					Action:           subscriber.AddSubscriber,
+					Domains:          message.Domains,
+					KeyTypes:         message.KeyTypes,
+					SkipChainChanges: message.SkipChainChanges,
+					Channel:          s.channel,
+				}:
+				case <-ctx.Done():
+					logger.Info().Msg("Stopping subscriber")
+					return nil
+				}
+			case plugin.TypeResult:
+				invocation, ok := pending[message.ID]
+				if !ok {
+					logger.Error().Uint64("id", message.ID).Msg("Result from plugin for unknown invocation")
+					continue
+				}
+
+				delete(pending, message.ID)
+
+				if message.Error != "" {
+					invocation.Report(errors.New(message.Error))
+				} else {
+					invocation.Report(nil)
+				}
+			default:
+				logger.Error().Str("type", message.Type).Msg("Unexpected message from plugin")
+			}
+		}
+	}
+}
+
+func invokeMessage(id uint64, invocation subscriber.Invocation) plugin.Message {
+	message := plugin.Message{
+		Type:         plugin.TypeInvoke,
+		ID:           id,
+		Action:       invocation.Action,
+		Target:       invocation.Target,
+		Domain:       invocation.Domain,
+		KeyType:      invocation.KeyType,
+		Names:        invocation.Certificate.Names,
+		Fingerprints: &invocation.Fingerprints,
+		Cert:         string(invocation.Certificate.Cert),
+	}
+
+	if invocation.Action == subscriber.UpdateCertificate {
+		message.Key = string(invocation.Certificate.Key)
+	}
+
+	return message
+}
+
+// Drain waits until the plugin answered the invocations queued when it was
+// called.
+func (s *Subscriber) Drain(ctx context.Context) error {
+	return subscriber.Drain(ctx, s.drainChannel)
+}
+
+// Ready reports whether the plugin is set up.
+func (s *Subscriber) Ready() error {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		return errors.New("plugin isn't ready")
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/plugin"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"reflect"
+	"testing"
+)
+
+func TestInvokeMessage(t *testing.T) {
+	certificate := cert.Certificate{Names: []string{"a.example.com", "b.example.com"}, Cert: []byte("cert"), Key: []byte("key")}
+	fingerprints := certificate.Fingerprints()
+
+	tests := []struct {
+		action string
+		key    string
+	}{
+		{subscriber.UpdateCertificate, "key"},
+		{subscriber.RemoveCertificate, ""},
+	}
+
+	for _, test := range tests {
+		message := invokeMessage(1, subscriber.Invocation{
+			Action:       test.action,
+			Domain:       "a.example.com",
+			Certificate:  certificate,
+			Fingerprints: fingerprints,
+		})
+
+		if message.Type != plugin.TypeInvoke || message.Action != test.action || message.Cert != "cert" || message.Key != test.key {
+			t.Errorf("unexpected %s message %+v", test.action, message)
+		}
+
+		if !reflect.DeepEqual(message.Names, certificate.Names) {
+			t.Errorf("unexpected names %v", message.Names)
+		}
+
+		if message.Fingerprints == nil || *message.Fingerprints != fingerprints {
+			t.Errorf("unexpected fingerprints %v", message.Fingerprints)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package podman
+
+import (
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber/container"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber/docker"
+	"time"
+)
+
+// apiVersion is the version of the Docker compatible API served by Podman.
+const apiVersion = "1.40"
+
+// Subscriber subscribes Podman containers using the Docker compatible API of
+// the Podman socket.
+type Subscriber struct {
+	Endpoint       string           `description:"Podman socket, the rootful socket when empty" json:"endpoint" yaml:"endpoint"`
+	ClientTimeout  time.Duration    `description:"Timeout of requests to Podman" json:"client_timeout" yaml:"client_timeout"`
+	UnhealthyAfter time.Duration    `description:"Time without connection to Podman after which the subscriber is unhealthy" json:"unhealthy_after" yaml:"unhealthy_after"`
+	Rules          []container.Rule `description:"Rules configuring containers without labels" json:"rules" yaml:"rules"`
+
+	*container.Subscriber `json:"-" yaml:"-"`
+}
+
+func init() {
+	subscriber.Register(subscriber.Registration{
+		Type:   "podman",
+		Config: func() interface{} { return &Subscriber{} },
+		New: func(config interface{}) (subscriber.Subscriber, error) {
+			return config.(*Subscriber), nil
+		},
+	})
+}
+
+func (s *Subscriber) Init() error {
+	if s.Endpoint == "" {
+		s.Endpoint = "unix:///run/podman/podman.sock"
+	}
+
+	s.Subscriber = &container.Subscriber{
+		Runtime: "podman",
+		Connect: func() (container.Runtime, error) {
+			return docker.Connect(s.Endpoint, s.ClientTimeout, apiVersion)
+		},
+		UnhealthyAfter: s.UnhealthyAfter,
+		Rules:          s.Rules,
+	}
+
+	return s.Subscriber.Init()
+}
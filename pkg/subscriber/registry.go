@@ -0,0 +1,58 @@
+package subscriber
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registration describes a subscriber type which can be configured.
+type Registration struct {
+	// Type is the name of the type used in the configuration.
+	Type string
+	// Config returns a new configuration the configuration of an instance is
+	// decoded into.
+	Config func() interface{}
+	// New creates a subscriber from the decoded configuration.
+	New func(config interface{}) (Subscriber, error)
+}
+
+var (
+	registryLock  sync.RWMutex
+	registrations = map[string]Registration{}
+)
+
+// Register makes a subscriber type available to the configuration, it is meant
+// to be called from the init function of the package implementing the type.
+func Register(registration Registration) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registrations[registration.Type]; ok {
+		panic("subscriber type " + registration.Type + " registered twice")
+	}
+
+	registrations[registration.Type] = registration
+}
+
+func Lookup(typeName string) (Registration, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	registration, ok := registrations[typeName]
+
+	return registration, ok
+}
+
+// Types returns the names of the registered types.
+func Types() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	types := make([]string, 0, len(registrations))
+	for typeName := range registrations {
+		types = append(types, typeName)
+	}
+	sort.Strings(types)
+
+	return types
+}
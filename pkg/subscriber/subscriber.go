@@ -2,7 +2,9 @@ package subscriber
 
 import (
 	"context"
+	"errors"
 	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"time"
 )
 
 const (
@@ -10,21 +12,127 @@ const (
 	RemoveSubscriber = "remove_subscriber"
 )
 
+const (
+	UpdateCertificate = "update_certificate"
+	RemoveCertificate = "remove_certificate"
+)
+
+// Invocation delivers the certificate of a domain to a subscriber. With the
+// RemoveCertificate action the domain no longer has a certificate and
+// Certificate holds the certificate which was removed. Subscribers report the
+// outcome of handling the invocation using Report, results are dropped once
+// Done is closed.
 type Invocation struct {
-	Domain 		string
-	Certificate cert.Certificate
-	Data        interface{}
+	Action         string
+	SubscriberName string
+	Target         string
+	Domain         string
+	KeyType        string
+	Certificate    cert.Certificate
+	Fingerprints   cert.Fingerprints
+	Data           interface{}
+	Results        chan<- Result
+	Done           <-chan struct{}
+}
+
+// Result is the outcome of handling an Invocation, Error is nil on success.
+type Result struct {
+	Invocation Invocation
+	Error      error
+	Finished   time.Time
+}
+
+// Report sends the result of handling the invocation. It never blocks, so it
+// is safe to call while the tracker is busy invoking subscribers.
+func (i Invocation) Report(err error) {
+	if i.Results == nil {
+		return
+	}
+
+	result := Result{
+		Invocation: i,
+		Error:      err,
+		Finished:   time.Now(),
+	}
+
+	go func() {
+		select {
+		case i.Results <- result:
+		case <-i.Done:
+		}
+	}()
 }
 
+// Message subscribes to the certificates of the domains. Target is a human
+// readable name of the subscribing entity, e.g. a container name. KeyTypes
+// requests a certificate per key type (ecdsa, rsa or ed25519), when empty only
+// the best certificate, whatever its key type, is delivered. SkipChainChanges
+// skips invocations for changes of only the chain, with the same leaf and key.
 type Message struct {
-	SubscriberName string
-	Action         string
-	Domains        []string
-	UpdateData     interface{}
-	Channel        chan<- Invocation
+	SubscriberName   string
+	Target           string
+	Action           string
+	Domains          []string
+	KeyTypes         []string
+	SkipChainChanges bool
+	UpdateData       interface{}
+	Channel          chan<- Invocation
 }
 
+// Subscriber sends subscriptions to the subscription channel and handles the
+// invocations it receives for them. Subscribe blocks until the context is
+// done, an error is returned when the subscriber can't continue.
 type Subscriber interface {
 	Init() error
 	Subscribe(subscriptionChannel chan<- Message, parentCtx context.Context) error
 }
+
+// ErrUnknownTarget is returned by an Explainer for targets it doesn't manage.
+var ErrUnknownTarget = errors.New("unknown target")
+
+// Explanation describes how a subscriber interpreted the configuration of a
+// target, e.g. the labels of a container.
+type Explanation struct {
+	SubscriberName   string            `json:"subscriber"`
+	Target           string            `json:"target"`
+	Valid            bool              `json:"valid"`
+	Domains          []string          `json:"domains"`
+	KeyTypes         []string          `json:"key_types"`
+	SkipChainChanges bool              `json:"skip_chain_changes"`
+	Actions          []string          `json:"actions"`
+	RemoveActions    []string          `json:"remove_actions"`
+	Configuration    map[string]string `json:"configuration"`
+}
+
+// Explainer is implemented by subscribers which can explain the configuration
+// of their targets.
+type Explainer interface {
+	Explain(target string, ctx context.Context) (*Explanation, error)
+}
+
+// Drainer is implemented by subscribers which handle invocations
+// asynchronously. Drain returns once the invocations queued when it was called
+// are handled, or with the error of the context when it is done first.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// Drain implements Drainer for subscribers handling the invocations in a
+// single goroutine. The goroutine receives a channel from the drain channel,
+// which it closes once the invocations queued at that moment are handled.
+func Drain(ctx context.Context, drainChannel chan<- chan struct{}) error {
+	drained := make(chan struct{})
+
+	select {
+	case drainChannel <- drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,326 @@
+//go:build linux
+// +build linux
+
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	actionReload          = "reload"
+	actionRestart         = "restart"
+	actionReloadOrRestart = "reload-or-restart"
+)
+
+// pollInterval is the interval the state of a unit is checked with while
+// waiting for it to become active.
+const pollInterval = 500 * time.Millisecond
+
+// connection is the part of the systemd D-Bus API used by the subscriber, so
+// it can be replaced by a fake.
+type connection interface {
+	ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	ReloadOrRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	GetUnitPropertyContext(ctx context.Context, unit string, propertyName string) (*dbus.Property, error)
+	Close()
+}
+
+// Subscriber writes the certificates of its domains to files and then reloads
+// or restarts systemd units.
+type Subscriber struct {
+	Domains          []string      `description:"Domains to subscribe to" json:"domains" yaml:"domains"`
+	KeyTypes         []string      `description:"Key types to request a certificate for, the best certificate when empty" json:"key_types" yaml:"key_types"`
+	SkipChainChanges bool          `description:"Don't write files and reload units when only the chain changed" json:"skip_chain_changes" yaml:"skip_chain_changes"`
+	Directory        string        `description:"Directory the certificate and key files are written to" json:"directory" yaml:"directory"`
+	Units            []string      `description:"Units to reload or restart" json:"units" yaml:"units"`
+	Action           string        `description:"Either reload, restart or reload-or-restart" json:"action" yaml:"action"`
+	Timeout          time.Duration `description:"Time the units get to become active again" json:"timeout" yaml:"timeout"`
+	UserManager      bool          `description:"Connect to the service manager of the user instead of the system" json:"user_manager" yaml:"user_manager"`
+
+	connect      func(ctx context.Context) (connection, error)
+	channel      chan subscriber.Invocation
+	drainChannel chan chan struct{}
+}
+
+func init() {
+	subscriber.Register(subscriber.Registration{
+		Type:   "systemd",
+		Config: func() interface{} { return &Subscriber{} },
+		New: func(config interface{}) (subscriber.Subscriber, error) {
+			return config.(*Subscriber), nil
+		},
+	})
+}
+
+func (s *Subscriber) Init() error {
+	if len(s.Domains) == 0 {
+		return errors.New("systemd subscriber requires domains")
+	}
+
+	if s.Directory == "" {
+		return errors.New("systemd subscriber requires a directory")
+	}
+
+	if len(s.Units) == 0 {
+		return errors.New("systemd subscriber requires units")
+	}
+
+	switch s.Action {
+	case "":
+		s.Action = actionRestart
+	case actionReload, actionRestart, actionReloadOrRestart:
+	default:
+		return fmt.Errorf("unknown action %s, expected reload, restart or reload-or-restart", s.Action)
+	}
+
+	if s.Timeout == 0 {
+		s.Timeout = time.Minute
+	}
+
+	if s.connect == nil {
+		s.connect = s.connectDbus
+	}
+
+	s.channel = make(chan subscriber.Invocation, 10)
+	s.drainChannel = make(chan chan struct{})
+
+	return nil
+}
+
+func (s *Subscriber) connectDbus(ctx context.Context) (connection, error) {
+	if s.UserManager {
+		return dbus.NewUserConnectionContext(ctx)
+	}
+
+	return dbus.NewSystemConnectionContext(ctx)
+}
+
+func (s *Subscriber) Subscribe(subscriptionChannel chan<- subscriber.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("subscriber", "systemd").Logger()
+	ctx := logger.WithContext(parentCtx)
+
+	select {
+	case subscriptionChannel <- subscriber.Message{
+		Target:           strings.Join(s.Units, ","),
+		Action:           subscriber.AddSubscriber,
+		Domains:          s.Domains,
+		KeyTypes:         s.KeyTypes,
+		SkipChainChanges: s.SkipChainChanges,
+		Channel:          s.channel,
+	}:
+	case <-ctx.Done():
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Stopping subscriber")
+			return nil
+		case done := <-s.drainChannel:
+			s.drainInvocations(ctx)
+			close(done)
+		case invocation := <-s.channel:
+			invocation.Report(s.invoke(invocation, ctx))
+		}
+	}
+}
+
+// Drain handles the invocations queued when it was called.
+func (s *Subscriber) Drain(ctx context.Context) error {
+	return subscriber.Drain(ctx, s.drainChannel)
+}
+
+func (s *Subscriber) drainInvocations(ctx context.Context) {
+	for {
+		select {
+		case invocation := <-s.channel:
+			invocation.Report(s.invoke(invocation, ctx))
+		default:
+			return
+		}
+	}
+}
+
+func (s *Subscriber) invoke(invocation subscriber.Invocation, ctx context.Context) error {
+	logger := log.Ctx(ctx).With().
+		Str("domain", invocation.Domain).
+		Str("key_type", invocation.KeyType).
+		Str("invocation", invocation.Action).
+		Logger()
+
+	if invocation.Action == subscriber.RemoveCertificate {
+		// The units keep using the files, so they aren't removed.
+		logger.Info().Msg("Keeping files of removed certificate")
+		return nil
+	}
+
+	if err := s.writeFiles(invocation); err != nil {
+		logger.Error().Err(err).Msg("Failed writing certificate files")
+		return err
+	}
+
+	conn, err := s.connect(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed connecting to systemd")
+		return err
+	}
+	defer conn.Close()
+
+	for _, unit := range s.Units {
+		unitLogger := logger.With().Str("unit", unit).Str("action", s.Action).Logger()
+		if err := s.apply(conn, unit, &unitLogger, ctx); err != nil {
+			unitLogger.Error().Err(err).Msg("Failed applying certificate to unit")
+			return fmt.Errorf("%s: %w", unit, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Subscriber) apply(conn connection, unit string, logger *zerolog.Logger, parentCtx context.Context) error {
+	ctx, cancel := context.WithTimeout(parentCtx, s.Timeout)
+	defer cancel()
+
+	result := make(chan string, 1)
+
+	var err error
+	switch s.Action {
+	case actionReload:
+		_, err = conn.ReloadUnitContext(ctx, unit, "replace", result)
+	case actionRestart:
+		_, err = conn.RestartUnitContext(ctx, unit, "replace", result)
+	case actionReloadOrRestart:
+		_, err = conn.ReloadOrRestartUnitContext(ctx, unit, "replace", result)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Msg("Waiting for unit job")
+
+	select {
+	case status := <-result:
+		if status != "done" {
+			return fmt.Errorf("job finished with %s", status)
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("job didn't finish within %s", s.Timeout)
+	}
+
+	return s.waitActive(conn, unit, logger, ctx)
+}
+
+// waitActive waits until the unit is active, it fails as soon as the unit
+// failed.
+func (s *Subscriber) waitActive(conn connection, unit string, logger *zerolog.Logger, ctx context.Context) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		property, err := conn.GetUnitPropertyContext(ctx, unit, "ActiveState")
+		if err != nil {
+			return err
+		}
+
+		state, _ := property.Value.Value().(string)
+		switch state {
+		case "active":
+			logger.Info().Msg("Unit is active")
+			return nil
+		case "failed":
+			return fmt.Errorf("unit is %s", state)
+		}
+
+		logger.Debug().Str("state", state).Msg("Waiting for unit to become active")
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("unit didn't become active within %s, it is %s", s.Timeout, state)
+		}
+	}
+}
+
+// writeFiles replaces the certificate and key files of the domain. Both files
+// are written before either is renamed into place and each file is replaced
+// atomically, so units never read a partially written file. The pair isn't
+// replaced atomically, a unit reading the files between the renames can get
+// the new key with the old certificate, the units are only reloaded once both
+// files are replaced.
+func (s *Subscriber) writeFiles(invocation subscriber.Invocation) error {
+	name := strings.Replace(invocation.Domain, "*", "_", -1)
+	if len(s.KeyTypes) > 0 {
+		name += "." + invocation.KeyType
+	}
+
+	files := []struct {
+		path    string
+		content []byte
+		mode    os.FileMode
+	}{
+		{filepath.Join(s.Directory, name+".key"), invocation.Certificate.Key, 0600},
+		{filepath.Join(s.Directory, name+".crt"), invocation.Certificate.Cert, 0644},
+	}
+
+	var temporary []string
+	defer func() {
+		for _, path := range temporary {
+			os.Remove(path)
+		}
+	}()
+
+	for _, file := range files {
+		path, err := writeTemporaryFile(file.path, file.content, file.mode)
+		if err != nil {
+			return err
+		}
+		temporary = append(temporary, path)
+	}
+
+	for i, file := range files {
+		if err := os.Rename(temporary[i], file.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeTemporaryFile writes the content to a temporary file next to the path.
+func writeTemporaryFile(path string, content []byte, mode os.FileMode) (string, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
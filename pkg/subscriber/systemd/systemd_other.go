@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package systemd
+
+import (
+	"context"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+)
+
+// Subscriber is only supported on Linux, on other platforms it fails to
+// initialize.
+type Subscriber struct{}
+
+func init() {
+	subscriber.Register(subscriber.Registration{
+		Type:   "systemd",
+		Config: func() interface{} { return &Subscriber{} },
+		New: func(config interface{}) (subscriber.Subscriber, error) {
+			return config.(*Subscriber), nil
+		},
+	})
+}
+
+func (s *Subscriber) Init() error {
+	return errors.New("systemd subscriber is only supported on Linux")
+}
+
+func (s *Subscriber) Subscribe(subscriptionChannel chan<- subscriber.Message, parentCtx context.Context) error {
+	<-parentCtx.Done()
+	return nil
+}
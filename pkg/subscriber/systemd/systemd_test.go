@@ -0,0 +1,222 @@
+//go:build linux
+// +build linux
+
+package systemd
+
+import (
+	"context"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/coreos/go-systemd/v22/dbus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConnection finishes every job with result and reports the states of a
+// unit one after another, the last state is repeated.
+type fakeConnection struct {
+	lock   sync.Mutex
+	result string
+	states map[string][]string
+	jobs   []string
+	closed bool
+}
+
+func (c *fakeConnection) job(action string, name string, ch chan<- string) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.jobs = append(c.jobs, action+" "+name)
+	ch <- c.result
+
+	return len(c.jobs), nil
+}
+
+func (c *fakeConnection) ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(actionReload, name, ch)
+}
+
+func (c *fakeConnection) RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(actionRestart, name, ch)
+}
+
+func (c *fakeConnection) ReloadOrRestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.job(actionReloadOrRestart, name, ch)
+}
+
+func (c *fakeConnection) GetUnitPropertyContext(ctx context.Context, unit string, propertyName string) (*dbus.Property, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	states := c.states[unit]
+	state := states[0]
+	if len(states) > 1 {
+		c.states[unit] = states[1:]
+	}
+
+	// go-systemd has no constructor for arbitrary string properties.
+	property := dbus.PropDescription(state)
+	property.Name = propertyName
+
+	return &property, nil
+}
+
+func (c *fakeConnection) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.closed = true
+}
+
+func newTestCertificate(key string, certificate string) cert.Certificate {
+	return cert.Certificate{
+		Names: []string{"*.example.com"},
+		Key:   []byte(key),
+		Cert:  []byte(certificate),
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		units  []string
+		result string
+		states map[string][]string
+		jobs   []string
+		failed bool
+	}{
+		{
+			name:   "restart",
+			units:  []string{"a.service"},
+			result: "done",
+			states: map[string][]string{"a.service": {"activating", "active"}},
+			jobs:   []string{"restart a.service"},
+		},
+		{
+			name:   "reload units",
+			action: actionReload,
+			units:  []string{"a.service", "b.service"},
+			result: "done",
+			states: map[string][]string{"a.service": {"active"}, "b.service": {"reloading", "active"}},
+			jobs:   []string{"reload a.service", "reload b.service"},
+		},
+		{
+			name:   "job failed",
+			action: actionReloadOrRestart,
+			units:  []string{"a.service", "b.service"},
+			result: "failed",
+			states: map[string][]string{"a.service": {"active"}, "b.service": {"active"}},
+			jobs:   []string{"reload-or-restart a.service"},
+			failed: true,
+		},
+		{
+			name:   "unit failed",
+			units:  []string{"a.service"},
+			result: "done",
+			states: map[string][]string{"a.service": {"activating", "failed"}},
+			jobs:   []string{"restart a.service"},
+			failed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &fakeConnection{result: test.result, states: test.states}
+			s := &Subscriber{
+				Domains:   []string{"*.example.com"},
+				Directory: t.TempDir(),
+				Units:     test.units,
+				Action:    test.action,
+				Timeout:   5 * time.Second,
+				connect: func(ctx context.Context) (connection, error) {
+					return conn, nil
+				},
+			}
+			if err := s.Init(); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.invoke(subscriber.Invocation{
+				Action:      subscriber.UpdateCertificate,
+				Domain:      "*.example.com",
+				Certificate: newTestCertificate("key", "certificate"),
+			}, context.Background())
+			if (err != nil) != test.failed {
+				t.Errorf("unexpected result %v", err)
+			}
+
+			if !reflect.DeepEqual(conn.jobs, test.jobs) {
+				t.Errorf("ran jobs %v, expected %v", conn.jobs, test.jobs)
+			}
+
+			if !conn.closed {
+				t.Error("connection not closed")
+			}
+		})
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	s := &Subscriber{
+		Domains:   []string{"*.example.com"},
+		Directory: t.TempDir(),
+		Units:     []string{"a.service"},
+	}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"first", "second"} {
+		err := s.writeFiles(subscriber.Invocation{
+			Domain:      "*.example.com",
+			Certificate: newTestCertificate(content+" key", content+" certificate"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(s.Directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("expected only the key and certificate, found %d files", len(files))
+	}
+
+	expected := map[string]struct {
+		content string
+		mode    os.FileMode
+	}{
+		"_.example.com.key": {"second key", 0600},
+		"_.example.com.crt": {"second certificate", 0644},
+	}
+
+	for name, file := range expected {
+		path := filepath.Join(s.Directory, name)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(content) != file.content {
+			t.Errorf("%s contains %q, expected %q", name, content, file.content)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != file.mode {
+			t.Errorf("%s has mode %s, expected %s", name, info.Mode().Perm(), file.mode)
+		}
+	}
+}
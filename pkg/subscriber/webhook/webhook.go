@@ -0,0 +1,413 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultSignatureHeader = "X-Cert-Watcher-Signature"
+
+// Subscriber sends an HTTP request for every invocation of its domains.
+type Subscriber struct {
+	Domains            []string          `description:"Domains to subscribe to" json:"domains" yaml:"domains"`
+	KeyTypes           []string          `description:"Key types to request a certificate for, the best certificate when empty" json:"key_types" yaml:"key_types"`
+	SkipChainChanges   bool              `description:"Don't send a request when only the chain changed" json:"skip_chain_changes" yaml:"skip_chain_changes"`
+	URL                string            `description:"URL to send the request to" json:"url" yaml:"url"`
+	Method             string            `description:"HTTP method of the request" json:"method" yaml:"method"`
+	Headers            map[string]string `description:"Headers of the request" json:"-" yaml:"headers"`
+	Body               string            `description:"Go template of the body, the event is encoded as JSON when empty" json:"body" yaml:"body"`
+	IncludeCertificate bool              `description:"Include the PEM encoded certificate in the event" json:"include_certificate" yaml:"include_certificate"`
+	IncludeKey         bool              `description:"Include the PEM encoded key in the event" json:"include_key" yaml:"include_key"`
+	Secret             string            `description:"Secret to sign the body with using HMAC-SHA256" json:"-" yaml:"secret"`
+	SignatureHeader    string            `description:"Header holding the signature" json:"signature_header" yaml:"signature_header"`
+	Timeout            time.Duration     `description:"Timeout of a single request" json:"timeout" yaml:"timeout"`
+	DeadLetter         string            `description:"File failed deliveries are appended to as JSON lines" json:"dead_letter" yaml:"dead_letter"`
+
+	client       *http.Client
+	template     *template.Template
+	channel      chan subscriber.Invocation
+	drainChannel chan chan struct{}
+
+	deadLetterLock sync.Mutex
+}
+
+// Event describes the invocation, it is the data of the body template.
+type Event struct {
+	Action      string    `json:"action"`
+	Subscriber  string    `json:"subscriber"`
+	Domain      string    `json:"domain"`
+	KeyType     string    `json:"key_type"`
+	Names       []string  `json:"names"`
+	Fingerprint string    `json:"fingerprint"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Certificate string    `json:"certificate,omitempty"`
+	Key         string    `json:"key,omitempty"`
+}
+
+// delivery sends the request of an invocation. A later invocation for the same
+// domain and key type supersedes it, which stops its retries, and only starts
+// once it finished.
+type delivery struct {
+	key        string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	superseded chan struct{}
+	done       chan struct{}
+}
+
+func (d *delivery) supersede() {
+	close(d.superseded)
+	d.cancel()
+}
+
+// deadLetter is a line of the dead letter file.
+type deadLetter struct {
+	Time   time.Time `json:"time"`
+	URL    string    `json:"url"`
+	Method string    `json:"method"`
+	Error  string    `json:"error"`
+	Event  Event     `json:"event"`
+}
+
+// statusError is returned for responses without a 2xx status.
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.status, e.body)
+}
+
+func init() {
+	subscriber.Register(subscriber.Registration{
+		Type:   "webhook",
+		Config: func() interface{} { return &Subscriber{} },
+		New: func(config interface{}) (subscriber.Subscriber, error) {
+			return config.(*Subscriber), nil
+		},
+	})
+}
+
+func (s *Subscriber) Init() error {
+	if len(s.Domains) == 0 {
+		return errors.New("webhook subscriber requires domains")
+	}
+
+	if _, err := url.ParseRequestURI(s.URL); err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	if s.Method == "" {
+		s.Method = http.MethodPost
+	}
+
+	if s.SignatureHeader == "" {
+		s.SignatureHeader = defaultSignatureHeader
+	}
+
+	if s.Timeout == 0 {
+		s.Timeout = 30 * time.Second
+	}
+
+	if s.Body != "" {
+		var err error
+		s.template, err = template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(s.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.client = &http.Client{Timeout: s.Timeout}
+	s.channel = make(chan subscriber.Invocation, 10)
+	s.drainChannel = make(chan chan struct{})
+
+	return nil
+}
+
+func (s *Subscriber) Subscribe(subscriptionChannel chan<- subscriber.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("subscriber", "webhook").Logger()
+	ctx := logger.WithContext(parentCtx)
+
+	select {
+	case subscriptionChannel <- subscriber.Message{
+		Target:           s.target(),
+		Action:           subscriber.AddSubscriber,
+		Domains:          s.Domains,
+		KeyTypes:         s.KeyTypes,
+		SkipChainChanges: s.SkipChainChanges,
+		Channel:          s.channel,
+	}:
+	case <-ctx.Done():
+		return nil
+	}
+
+	// Every invocation is delivered in its own goroutine, so retrying a
+	// delivery doesn't hold up the deliveries of other domains.
+	active := map[string]*delivery{}
+	running := 0
+	finished := make(chan *delivery)
+	var drained []chan struct{}
+
+	for {
+		if len(drained) > 0 && running == 0 && len(s.channel) == 0 {
+			for _, done := range drained {
+				close(done)
+			}
+			drained = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info().Int("running", running).Msg("Stopping subscriber")
+			for ; running > 0; running-- {
+				<-finished
+			}
+			return nil
+		case done := <-s.drainChannel:
+			drained = append(drained, done)
+		case invocation := <-s.channel:
+			d := &delivery{
+				key:        invocation.Domain + "|" + invocation.KeyType,
+				superseded: make(chan struct{}),
+				done:       make(chan struct{}),
+			}
+			d.ctx, d.cancel = context.WithCancel(ctx)
+
+			previous := active[d.key]
+			if previous != nil {
+				previous.supersede()
+			}
+			active[d.key] = d
+
+			running++
+			go func() {
+				if previous != nil {
+					<-previous.done
+				}
+
+				invocation.Report(s.invoke(invocation, d))
+				close(d.done)
+				finished <- d
+			}()
+		case d := <-finished:
+			running--
+			d.cancel()
+			if active[d.key] == d {
+				delete(active, d.key)
+			}
+		}
+	}
+}
+
+// Drain sends the requests of the invocations queued when it was called.
+func (s *Subscriber) Drain(ctx context.Context) error {
+	return subscriber.Drain(ctx, s.drainChannel)
+}
+
+func (s *Subscriber) target() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return s.URL
+	}
+
+	return u.Host
+}
+
+func (s *Subscriber) invoke(invocation subscriber.Invocation, d *delivery) error {
+	logger := log.Ctx(d.ctx).With().
+		Str("domain", invocation.Domain).
+		Str("key_type", invocation.KeyType).
+		Str("invocation", invocation.Action).
+		Logger()
+
+	event, err := s.event(invocation)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to describe certificate")
+		return err
+	}
+
+	body, err := s.body(event)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to render body")
+		s.writeDeadLetter(event, err, &logger)
+		return err
+	}
+
+	logger.Info().Msg("Sending webhook")
+
+	operation := func() error {
+		return s.send(body, d.ctx)
+	}
+
+	notify := func(err error, time time.Duration) {
+		logger.Error().Err(err).Dur("retry_at", time).Msg("Sending webhook failed, retrying later")
+	}
+
+	err = backoff.RetryNotify(
+		operation,
+		backoff.WithContext(backoff.NewExponentialBackOff(), d.ctx),
+		notify,
+	)
+	if err != nil {
+		select {
+		case <-d.superseded:
+			logger.Info().Err(err).Msg("Webhook superseded by a later invocation")
+			return err
+		default:
+		}
+
+		logger.Error().Err(err).Msg("Sending webhook failed permanently, not retrying")
+		s.writeDeadLetter(event, err, &logger)
+		return err
+	}
+
+	logger.Info().Msg("Sent webhook")
+	return nil
+}
+
+func (s *Subscriber) event(invocation subscriber.Invocation) (Event, error) {
+	event := Event{
+		Action:      invocation.Action,
+		Subscriber:  invocation.SubscriberName,
+		Domain:      invocation.Domain,
+		KeyType:     invocation.KeyType,
+		Names:       invocation.Certificate.Names,
+		Fingerprint: invocation.Fingerprints.Leaf,
+	}
+
+	leaf, err := invocation.Certificate.Leaf()
+	if err != nil {
+		return event, err
+	}
+
+	event.NotBefore = leaf.NotBefore
+	event.NotAfter = leaf.NotAfter
+
+	if s.IncludeCertificate {
+		event.Certificate = string(invocation.Certificate.Cert)
+	}
+
+	if s.IncludeKey {
+		event.Key = string(invocation.Certificate.Key)
+	}
+
+	return event, nil
+}
+
+func (s *Subscriber) body(event Event) ([]byte, error) {
+	if s.template == nil {
+		return json.Marshal(event)
+	}
+
+	var body bytes.Buffer
+	if err := s.template.Execute(&body, event); err != nil {
+		return nil, err
+	}
+
+	return body.Bytes(), nil
+}
+
+// send sends the request once, only failures which might be resolved by
+// retrying, e.g. server errors, aren't permanent.
+func (s *Subscriber) send(body []byte, ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, s.Method, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+
+	if s.Body == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if s.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.Secret))
+		mac.Write(body)
+		req.Header.Set(s.SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = &statusError{status: resp.StatusCode, body: strings.TrimSpace(string(content))}
+
+	switch {
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return err
+	default:
+		return backoff.Permanent(err)
+	}
+}
+
+func (s *Subscriber) writeDeadLetter(event Event, err error, logger *zerolog.Logger) {
+	if s.DeadLetter == "" {
+		return
+	}
+
+	// The key isn't written to disk.
+	event.Key = ""
+
+	line, marshalErr := json.Marshal(deadLetter{
+		Time:   time.Now(),
+		URL:    s.URL,
+		Method: s.Method,
+		Error:  err.Error(),
+		Event:  event,
+	})
+	if marshalErr != nil {
+		logger.Error().Err(marshalErr).Msg("Unable to encode dead letter")
+		return
+	}
+
+	s.deadLetterLock.Lock()
+	defer s.deadLetterLock.Unlock()
+
+	file, openErr := os.OpenFile(s.DeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if openErr != nil {
+		logger.Error().Err(openErr).Msg("Unable to open dead letter file")
+		return
+	}
+	defer file.Close()
+
+	if _, writeErr := file.Write(append(line, '\n')); writeErr != nil {
+		logger.Error().Err(writeErr).Msg("Unable to write dead letter")
+	}
+}
+
+func toJSON(value interface{}) (string, error) {
+	content, err := json.Marshal(value)
+	return string(content), err
+}
@@ -0,0 +1,255 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/fallback"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T) *cert.Certificate {
+	t.Helper()
+
+	issuer := &fallback.Issuer{}
+	if err := issuer.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := issuer.Issue("a.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate
+}
+
+func startSubscriber(t *testing.T, s *Subscriber) {
+	t.Helper()
+
+	s.Domains = []string{"a.example.com"}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Subscribe(make(chan subscriber.Message, 1), ctx)
+		close(stopped)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+func invoke(s *Subscriber, certificate *cert.Certificate) <-chan subscriber.Result {
+	results := make(chan subscriber.Result, 1)
+	s.channel <- subscriber.Invocation{
+		Action:       subscriber.UpdateCertificate,
+		Domain:       "a.example.com",
+		Certificate:  *certificate,
+		Fingerprints: certificate.Fingerprints(),
+		Results:      results,
+	}
+
+	return results
+}
+
+func result(t *testing.T, results <-chan subscriber.Result) error {
+	t.Helper()
+
+	select {
+	case result := <-results:
+		return result.Error
+	case <-time.After(10 * time.Second):
+		t.Fatal("invocation not handled")
+		return nil
+	}
+}
+
+func TestSignature(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		received <- req
+		bodies <- body
+	}))
+	defer server.Close()
+
+	s := &Subscriber{
+		URL:     server.URL,
+		Secret:  "secret",
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	}
+	startSubscriber(t, s)
+
+	if err := result(t, invoke(s, newTestCertificate(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	req, body := <-received, <-bodies
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	if signature := req.Header.Get(defaultSignatureHeader); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("invalid signature %q", signature)
+	}
+
+	if req.Header.Get("Authorization") != "Bearer token" {
+		t.Error("configured header not sent")
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+
+	if event.Domain != "a.example.com" || event.Key != "" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	config, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(config, []byte("Bearer token")) || bytes.Contains(config, []byte("secret")) {
+		t.Errorf("headers or secret in encoded configuration %s", config)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		failed   bool
+	}{
+		{"success", []int{http.StatusOK}, 1, false},
+		{"server error", []int{http.StatusServiceUnavailable, http.StatusOK}, 2, false},
+		{"too many requests", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"bad request", []int{http.StatusBadRequest, http.StatusOK}, 1, true},
+		{"not found", []int{http.StatusNotFound, http.StatusOK}, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				rw.WriteHeader(test.statuses[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			s := &Subscriber{URL: server.URL}
+			startSubscriber(t, s)
+
+			err := result(t, invoke(s, newTestCertificate(t)))
+			if (err != nil) != test.failed {
+				t.Errorf("unexpected result %v", err)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if requests != test.requests {
+				t.Errorf("sent %d requests, expected %d", requests, test.requests)
+			}
+		})
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "rejected", http.StatusUnprocessableEntity)
+	}))
+	defer server.Close()
+
+	s := &Subscriber{
+		URL:                server.URL,
+		IncludeCertificate: true,
+		IncludeKey:         true,
+		DeadLetter:         filepath.Join(t.TempDir(), "dead-letter.jsonl"),
+	}
+	startSubscriber(t, s)
+
+	certificate := newTestCertificate(t)
+	if err := result(t, invoke(s, certificate)); err == nil {
+		t.Fatal("rejected webhook didn't fail")
+	}
+
+	content, err := ioutil.ReadFile(s.DeadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected a single dead letter, got %d", len(lines))
+	}
+
+	var letter deadLetter
+	if err := json.Unmarshal([]byte(lines[0]), &letter); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(letter.Error, "422") || letter.Event.Certificate != string(certificate.Cert) {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+
+	if letter.Event.Key != "" || bytes.Contains(content, certificate.Key) {
+		t.Error("key written to dead letter")
+	}
+}
+
+func TestSupersede(t *testing.T) {
+	first, second := newTestCertificate(t), newTestCertificate(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var event Event
+		json.NewDecoder(req.Body).Decode(&event)
+		if event.Fingerprint == first.Fingerprints().Leaf {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	s := &Subscriber{
+		URL:        server.URL,
+		DeadLetter: filepath.Join(t.TempDir(), "dead-letter.jsonl"),
+	}
+	startSubscriber(t, s)
+
+	firstResults := invoke(s, first)
+	secondResults := invoke(s, second)
+
+	if err := result(t, firstResults); err == nil {
+		t.Error("superseded webhook didn't fail")
+	}
+
+	if err := result(t, secondResults); err != nil {
+		t.Error(err)
+	}
+
+	if content, _ := ioutil.ReadFile(s.DeadLetter); len(content) > 0 {
+		t.Errorf("superseded webhook written to dead letter: %s", content)
+	}
+}
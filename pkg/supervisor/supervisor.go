@@ -0,0 +1,203 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/health"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	Running    State = "running"
+	Restarting State = "restarting"
+	Failed     State = "failed"
+	Stopped    State = "stopped"
+)
+
+// stableAfter is the time a member has to run before its backoff is reset.
+const stableAfter = 5 * time.Minute
+
+// errStopped is reported for members which returned without error before
+// their context was done.
+var errStopped = errors.New("stopped unexpectedly")
+
+// Status describes the state of a supervised member.
+type Status struct {
+	Kind        string     `json:"kind"`
+	Name        string     `json:"name"`
+	State       State      `json:"state"`
+	Since       time.Time  `json:"since"`
+	Restarts    int        `json:"restarts"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	NextRestart *time.Time `json:"next_restart,omitempty"`
+}
+
+// Reporter is implemented by components which supervise members.
+type Reporter interface {
+	Statuses() []Status
+}
+
+// Supervisor runs members and restarts them with backoff when they fail.
+// Members which fail with a backoff.PermanentError aren't restarted.
+type Supervisor struct {
+	kind string
+
+	mutex   sync.Mutex
+	members map[string]*Status
+}
+
+func New(kind string) *Supervisor {
+	return &Supervisor{
+		kind:    kind,
+		members: map[string]*Status{},
+	}
+}
+
+// Run runs the member until the context is done, restarting it whenever it
+// returns or panics before that.
+func (s *Supervisor) Run(parentCtx context.Context, name string, run func(ctx context.Context) error) {
+	logger := log.Ctx(parentCtx).With().Str("member", s.kind+"/"+name).Logger()
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxInterval = 5 * time.Minute
+	b.MaxElapsedTime = 0
+
+	for {
+		s.update(name, func(status *Status) {
+			status.State = Running
+			status.NextRestart = nil
+		})
+
+		started := time.Now()
+		err := runMember(parentCtx, run)
+		if parentCtx.Err() != nil {
+			s.update(name, func(status *Status) {
+				status.State = Stopped
+			})
+			return
+		}
+
+		if err == nil {
+			err = errStopped
+		}
+
+		var permanent *backoff.PermanentError
+		if errors.As(err, &permanent) {
+			logger.Error().Err(permanent.Err).Msg("Member failed permanently, not restarting")
+			s.failed(name, permanent.Err, Failed, nil)
+			return
+		}
+
+		if time.Since(started) > stableAfter {
+			b.Reset()
+		}
+
+		delay := b.NextBackOff()
+		restartAt := time.Now().Add(delay)
+		logger.Error().Err(err).Dur("restart_in", delay).Msg("Member failed, restarting")
+		s.failed(name, err, Restarting, &restartAt)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-parentCtx.Done():
+			timer.Stop()
+			s.update(name, func(status *Status) {
+				status.State = Stopped
+				status.NextRestart = nil
+			})
+			return
+		}
+
+		s.update(name, func(status *Status) {
+			status.Restarts++
+		})
+		logger.Info().Msg("Restarting member")
+	}
+}
+
+func runMember(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	return run(ctx)
+}
+
+func (s *Supervisor) failed(name string, err error, state State, nextRestart *time.Time) {
+	now := time.Now()
+	s.update(name, func(status *Status) {
+		status.State = state
+		status.LastError = err.Error()
+		status.LastFailure = &now
+		status.NextRestart = nextRestart
+	})
+}
+
+func (s *Supervisor) update(name string, update func(status *Status)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status, ok := s.members[name]
+	if !ok {
+		status = &Status{Kind: s.kind, Name: name}
+		s.members[name] = status
+	}
+
+	previous := status.State
+	update(status)
+	if status.State != previous {
+		status.Since = time.Now()
+	}
+}
+
+// Statuses returns the status of every member, sorted by name.
+func (s *Supervisor) Statuses() []Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := make([]Status, 0, len(s.members))
+	for _, status := range s.members {
+		statuses = append(statuses, *status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// Ready reports members which aren't running.
+func (s *Supervisor) Ready() error {
+	var errs []error
+	for _, status := range s.Statuses() {
+		if status.State != Running {
+			errs = append(errs, fmt.Errorf("%s/%s is %s", status.Kind, status.Name, status.State))
+		}
+	}
+
+	return health.Combine(errs...)
+}
+
+// Healthy reports members which failed permanently.
+func (s *Supervisor) Healthy() error {
+	var errs []error
+	for _, status := range s.Statuses() {
+		if status.State == Failed {
+			errs = append(errs, fmt.Errorf("%s/%s failed: %s", status.Kind, status.Name, status.LastError))
+		}
+	}
+
+	return health.Combine(errs...)
+}
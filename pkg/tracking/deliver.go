@@ -0,0 +1,153 @@
+package tracking
+
+import (
+	"context"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+)
+
+// Choice is the certificate which is chosen for a domain and requested key
+// type, Certificate is nil when no certificate covers the domain.
+type Choice struct {
+	Domain           string               `json:"domain"`
+	RequestedKeyType string               `json:"requested_key_type"`
+	Certificate      *SelectedCertificate `json:"certificate"`
+}
+
+// Explanation combines how a subscriber interpreted a target with the
+// certificates which would be chosen for it.
+type Explanation struct {
+	*subscriber.Explanation
+	Certificates []Choice `json:"certificates"`
+}
+
+type deliverRequest struct {
+	target   string
+	response chan int
+}
+
+type chooseRequest struct {
+	domains  []string
+	keyTypes []string
+	response chan []Choice
+}
+
+// Deliver invokes the subscribers of the target again, even when their
+// certificates didn't change. The target is either a domain or the name of a
+// subscriber target, e.g. a container. It returns the number of invocations.
+func (t *Tracker) Deliver(ctx context.Context, target string) (int, error) {
+	request := deliverRequest{
+		target:   target,
+		response: make(chan int, 1),
+	}
+
+	select {
+	case t.deliverChan <- request:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	select {
+	case invocations := <-request.response:
+		return invocations, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Choose reports which certificates would be chosen for the domains and key
+// types, regardless of them being subscribed to.
+func (t *Tracker) Choose(ctx context.Context, domains []string, keyTypes []string) ([]Choice, error) {
+	request := chooseRequest{
+		domains:  domains,
+		keyTypes: keyTypes,
+		response: make(chan []Choice, 1),
+	}
+
+	select {
+	case t.chooseChan <- request:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case choices := <-request.response:
+		return choices, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *Tracker) deliver(target string) int {
+	domain, isDomain := normalizeDomain(target)
+
+	invocations := 0
+	for _, item := range t.items {
+		for _, subscr := range item.subscribers {
+			if !(isDomain && item.domain == domain) &&
+				subscr.Target != target &&
+				SubscriberKey(subscr.SubscriberName, subscr.Target) != target {
+				continue
+			}
+
+			for _, keyType := range subscriberKeyTypes(subscr) {
+				s, ok := item.slots[keyType]
+				if !ok || s.certificate == nil {
+					continue
+				}
+
+				item.invokeSubscriber(subscr, s)
+				invocations++
+			}
+		}
+	}
+
+	return invocations
+}
+
+func (t *Tracker) choose(domains []string, keyTypes []string) []Choice {
+	if len(keyTypes) == 0 {
+		keyTypes = []string{anyKeyType}
+	}
+
+	choices := []Choice{}
+	for _, requested := range domains {
+		domain, ok := normalizeDomain(requested)
+		if !ok {
+			domain = requested
+		}
+
+		for _, keyType := range keyTypes {
+			choice := Choice{
+				Domain:           domain,
+				RequestedKeyType: keyType,
+			}
+
+			if best, reason := bestCandidate(t.certificates, domain, keyType, t.selection.forDomain(domain)); best != nil {
+				choice.Certificate = &SelectedCertificate{
+					RequestedKeyType: keyType,
+					KeyType:          best.keyType,
+					Source:           best.source,
+					Provider:         best.provider,
+					Reason:           reason,
+					NotAfter:         best.notAfter,
+					Fingerprints:     best.certificate.Fingerprints(),
+				}
+			} else if item, ok := t.items[domain]; ok {
+				if s, ok := item.slots[keyType]; ok && s.fallback && s.certificate != nil {
+					choice.Certificate = &SelectedCertificate{
+						RequestedKeyType: keyType,
+						KeyType:          s.actualType,
+						Reason:           s.reason,
+						Fallback:         true,
+						NotAfter:         s.notAfter,
+						Fingerprints:     s.fingerprints,
+					}
+				}
+			}
+
+			choices = append(choices, choice)
+		}
+	}
+
+	return choices
+}
@@ -0,0 +1,45 @@
+package tracking
+
+import (
+	"regexp"
+	"strings"
+)
+
+var labelMatcher = regexp.MustCompile("^[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9_])?$")
+
+// normalizeDomain lowercases the domain and strips surrounding whitespace and
+// a trailing dot, it returns false if the result isn't a valid domain name.
+// A wildcard is only accepted as the complete left-most label.
+func normalizeDomain(domain string) (string, bool) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" || len(domain) > 253 {
+		return domain, false
+	}
+
+	for i, label := range strings.Split(domain, ".") {
+		if i == 0 && label == "*" && domain != "*" {
+			continue
+		}
+
+		if !labelMatcher.MatchString(label) {
+			return domain, false
+		}
+	}
+
+	return domain, true
+}
+
+// wildcardName returns the wildcard name covering the domain, single label
+// domains, like localhost, can't be covered by a wildcard.
+func wildcardName(domain string) (string, bool) {
+	if strings.HasPrefix(domain, "*.") {
+		return domain, true
+	}
+
+	index := strings.Index(domain, ".")
+	if index < 0 {
+		return "", false
+	}
+
+	return "*" + domain[index:], true
+}
@@ -1,57 +1,306 @@
 package tracking
 
 import (
-	"crypto/sha1"
+	"errors"
 	"github.com/RobertMe/cert-watcher/pkg/cert"
 	"github.com/RobertMe/cert-watcher/pkg/subscriber"
 	"github.com/rs/zerolog"
+	"time"
 )
 
+// anyKeyType is the slot of subscribers which didn't request specific key
+// types, it holds the best certificate whatever its key type.
+const anyKeyType = ""
+
 type item struct {
 	domain      string
-	certificate *cert.Certificate
+	slots       map[string]*slot
 	subscribers []subscriber.Message
-	sum         [sha1.Size]byte
-	logger      zerolog.Logger
+
+	lastDelivery time.Time
+	deliveries   map[string]*Delivery
+	results      chan<- subscriber.Result
+	done         <-chan struct{}
+	queues       *queues
+	served       map[string]bool
+
+	logger zerolog.Logger
 }
 
-func newItem(domain string, parentLogger *zerolog.Logger) *item {
+// slot holds the certificate selected for a domain and key type.
+type slot struct {
+	keyType      string
+	certificate  *cert.Certificate
+	actualType   string
+	fingerprints cert.Fingerprints
+	source       string
+	provider     string
+	reason       string
+	fallback     bool
+	notBefore    time.Time
+	notAfter     time.Time
+
+	logger zerolog.Logger
+}
+
+func newItem(domain string, results chan<- subscriber.Result, done <-chan struct{}, queues *queues, parentLogger *zerolog.Logger) *item {
 	return &item{
 		domain:      domain,
-		certificate: nil,
+		slots:       map[string]*slot{},
 		subscribers: []subscriber.Message{},
+		deliveries:  map[string]*Delivery{},
+		results:     results,
+		done:        done,
+		queues:      queues,
+		served:      map[string]bool{},
 		logger:      parentLogger.With().Str("certificate", domain).Logger(),
 	}
 }
 
-func (i *item) updateCertificate(certificate *cert.Certificate) {
-	sum := sha1.Sum(certificate.Cert)
-	if i.sum == sum {
-		i.logger.Info().Msg("Skipping certificate update as it didn't change")
+func subscriberKeyTypes(message subscriber.Message) []string {
+	if len(message.KeyTypes) == 0 {
+		return []string{anyKeyType}
+	}
+
+	return message.KeyTypes
+}
+
+func (i *item) slot(keyType string) *slot {
+	if s, ok := i.slots[keyType]; ok {
+		return s
+	}
+
+	s := &slot{
+		keyType: keyType,
+		logger:  i.logger.With().Str("key_type", keyType).Logger(),
+	}
+	i.slots[keyType] = s
+
+	return s
+}
+
+func (i *item) selectCandidate(s *slot, c *candidate, reason string) {
+	if s.source != c.source || s.provider != c.provider || s.reason != reason {
+		s.logger.Info().
+			Str("source", c.source).
+			Str("provider", c.provider).
+			Strs("names", c.names).
+			Time("not_after", c.notAfter).
+			Str("reason", reason).
+			Msg("Selected certificate")
+	}
+
+	s.source = c.source
+	s.provider = c.provider
+	s.reason = reason
+	if c.leaf != nil {
+		s.notBefore = c.leaf.NotBefore
+		s.notAfter = c.leaf.NotAfter
+	}
+	i.updateCertificate(s, c.certificate, c.keyType)
+}
+
+func (i *item) updateCertificate(s *slot, certificate *cert.Certificate, keyType string) {
+	fingerprints := certificate.Fingerprints()
+	if s.fingerprints == fingerprints {
+		s.logger.Info().Msg("Skipping certificate update as it didn't change")
 		return
 	}
 
-	i.certificate = certificate
-	i.sum = sum
+	chainOnly := s.certificate != nil &&
+		s.fingerprints.Leaf == fingerprints.Leaf &&
+		s.fingerprints.Key == fingerprints.Key
+
+	s.logger.Info().
+		Str("leaf_fingerprint", fingerprints.Leaf).
+		Str("chain_fingerprint", fingerprints.Chain).
+		Str("key_fingerprint", fingerprints.Key).
+		Bool("chain_only", chainOnly).
+		Msg("Certificate changed")
+
+	s.certificate = certificate
+	s.actualType = keyType
+	s.fingerprints = fingerprints
+	s.fallback = false
 
 	for _, subscr := range i.subscribers {
-		i.invokeSubscriber(subscr)
+		if chainOnly && subscr.SkipChainChanges {
+			s.logger.Debug().Str("subscriber", subscr.SubscriberName).Msg("Skipping subscriber for chain only change")
+			continue
+		}
+
+		for _, keyType := range subscriberKeyTypes(subscr) {
+			if keyType == s.keyType {
+				i.invokeSubscriber(subscr, s)
+			}
+		}
 	}
 }
 
+func (i *item) updateFallbackCertificate(s *slot, issuer Issuer) error {
+	certificate, err := issuer.Issue(i.domain, s.keyType)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := certificate.Leaf()
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info().Time("not_after", leaf.NotAfter).Msg("Issued fallback certificate")
+	i.updateCertificate(s, certificate, cert.KeyType(leaf))
+	s.fallback = true
+	s.source = ""
+	s.provider = ""
+	s.reason = "fallback"
+	s.notBefore = leaf.NotBefore
+	s.notAfter = leaf.NotAfter
+
+	return nil
+}
+
+// removeCertificate clears the slot after its certificate was withdrawn and
+// notifies the subscribers of the slot.
+func (i *item) removeCertificate(s *slot) {
+	s.logger.Info().Str("source", s.source).Msg("Certificate removed")
+
+	removed, fingerprints := s.certificate, s.fingerprints
+	s.certificate = nil
+	s.fingerprints = cert.Fingerprints{}
+	s.source = ""
+	s.provider = ""
+	s.reason = ""
+
+	for _, subscr := range i.subscribers {
+		for _, keyType := range subscriberKeyTypes(subscr) {
+			if keyType == s.keyType {
+				s.logger.Info().Str("subscriber", subscr.SubscriberName).Msg("Invoking subscriber for removal")
+				i.invoke(subscr, subscriber.Invocation{
+					Action:       subscriber.RemoveCertificate,
+					Domain:       i.domain,
+					KeyType:      s.actualType,
+					Certificate:  *removed,
+					Fingerprints: fingerprints,
+				})
+			}
+		}
+	}
+}
+
+// fallbackExpiring reports whether the fallback certificate passed two thirds
+// of its lifetime and should be replaced by a freshly issued one.
+func (s *slot) fallbackExpiring() bool {
+	return s.fallback && time.Until(s.notAfter) < s.notAfter.Sub(s.notBefore)/3
+}
+
+// addSubscriber adds the subscription, replacing an earlier subscription of
+// the same subscriber and target, e.g. after the subscriber restarted. The
+// certificate isn't delivered again when it was delivered already, unless that
+// delivery failed.
 func (i *item) addSubscriber(message subscriber.Message) {
-	i.subscribers = append(i.subscribers, message)
+	key := SubscriberKey(message.SubscriberName, message.Target)
 
-	if i.certificate != nil {
-		i.invokeSubscriber(message)
+	replaced := false
+	for index, subscr := range i.subscribers {
+		if SubscriberKey(subscr.SubscriberName, subscr.Target) == key {
+			i.subscribers[index] = message
+			replaced = true
+			break
+		}
 	}
+
+	if !replaced {
+		i.subscribers = append(i.subscribers, message)
+	}
+
+	for _, keyType := range subscriberKeyTypes(message) {
+		s := i.slot(keyType)
+		if s.certificate == nil {
+			continue
+		}
+
+		if replaced && i.delivered(key, s) {
+			s.logger.Debug().Str("subscriber", key).Msg("Certificate already delivered to subscriber")
+			continue
+		}
+
+		i.invokeSubscriber(message, s)
+	}
+}
+
+func (i *item) delivered(key string, s *slot) bool {
+	delivery, ok := i.deliveries[key+"|"+s.actualType]
+
+	return ok &&
+		delivery.Action == subscriber.UpdateCertificate &&
+		delivery.Fingerprint == s.fingerprints.Leaf &&
+		delivery.Error == ""
+}
+
+func (i *item) invokeSubscriber(subscr subscriber.Message, s *slot) {
+	s.logger.Info().Str("subscriber", subscr.SubscriberName).Msg("Invoking subscriber")
+	i.invoke(subscr, subscriber.Invocation{
+		Action:       subscriber.UpdateCertificate,
+		Domain:       i.domain,
+		KeyType:      s.actualType,
+		Certificate:  *s.certificate,
+		Fingerprints: s.fingerprints,
+	})
 }
 
-func (i *item) invokeSubscriber(subscr subscriber.Message) {
-	i.logger.Info().Str("subscriber", subscr.SubscriberName).Msg("Invoking subscriber")
-	subscr.Channel <- subscriber.Invocation{
-		Domain:      i.domain,
-		Certificate: *i.certificate,
-		Data:        subscr.UpdateData,
+func (i *item) invoke(subscr subscriber.Message, invocation subscriber.Invocation) {
+	invocation.SubscriberName = subscr.SubscriberName
+	invocation.Target = subscr.Target
+	invocation.Data = subscr.UpdateData
+	invocation.Results = i.results
+	invocation.Done = i.done
+
+	i.lastDelivery = time.Now()
+	i.deliveries[deliveryKey(invocation)] = &Delivery{
+		Subscriber:  invocation.SubscriberName,
+		Target:      invocation.Target,
+		Domain:      invocation.Domain,
+		KeyType:     invocation.KeyType,
+		Action:      invocation.Action,
+		Fingerprint: invocation.Fingerprints.Leaf,
+		Started:     i.lastDelivery,
 	}
+
+	i.queues.push(subscr.Channel, invocation)
+}
+
+// deliveryFinished records the result reported by the subscriber, results of
+// invocations which have since been superseded are ignored.
+func (i *item) deliveryFinished(result subscriber.Result) {
+	// The invocation superseding it reports the outcome.
+	if errors.Is(result.Error, errSuperseded) {
+		return
+	}
+
+	delivery, ok := i.deliveries[deliveryKey(result.Invocation)]
+	if !ok || delivery.Fingerprint != result.Invocation.Fingerprints.Leaf || delivery.Action != result.Invocation.Action {
+		return
+	}
+
+	finished := result.Finished
+	delivery.Finished = &finished
+	delivery.Error = ""
+
+	logger := i.logger.With().
+		Str("subscriber", SubscriberKey(delivery.Subscriber, delivery.Target)).
+		Str("key_type", delivery.KeyType).
+		Logger()
+
+	if result.Error != nil {
+		delivery.Error = result.Error.Error()
+		logger.Error().Err(result.Error).Msg("Subscriber failed handling certificate")
+		return
+	}
+
+	logger.Info().Msg("Subscriber handled certificate")
+}
+
+func deliveryKey(invocation subscriber.Invocation) string {
+	return SubscriberKey(invocation.SubscriberName, invocation.Target) + "|" + invocation.KeyType
 }
@@ -0,0 +1,143 @@
+package tracking
+
+import (
+	"crypto/x509"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	noMatch = iota
+	wildcardMatch
+	exactMatch
+)
+
+// candidate is a certificate provided by a watcher. A certificate covering
+// multiple names is a single candidate and is always delivered as a whole.
+type candidate struct {
+	source      string
+	provider    string
+	names       []string
+	certificate *cert.Certificate
+	sequence    uint64
+
+	leaf     *x509.Certificate
+	keyType  string
+	notAfter time.Time
+}
+
+func newCandidate(source string, provider string, certificate *cert.Certificate, sequence uint64) *candidate {
+	c := &candidate{
+		source:      source,
+		provider:    provider,
+		certificate: certificate,
+		sequence:    sequence,
+	}
+
+	for _, name := range certificate.Names {
+		if name, ok := normalizeDomain(name); ok {
+			c.names = append(c.names, name)
+		}
+	}
+
+	sort.Strings(c.names)
+
+	if leaf, err := certificate.Leaf(); err == nil {
+		c.leaf = leaf
+		c.keyType = cert.KeyType(leaf)
+		c.notAfter = leaf.NotAfter
+	}
+
+	return c
+}
+
+// key identifies the certificate across updates, a renewed certificate for the
+// same names and key type from the same watcher and provider replaces the
+// previous one.
+func (c *candidate) key() string {
+	return c.source + "|" + c.provider + "|" + c.keyType + "|" + strings.Join(c.names, ",")
+}
+
+// match reports how the candidate covers the domain. A wildcard only covers a
+// single label, so *.example.com covers a.example.com but neither example.com
+// nor a.b.example.com.
+func (c *candidate) match(domain string) int {
+	wildcard, hasWildcard := wildcardName(domain)
+	result := noMatch
+	for _, name := range c.names {
+		if name == domain {
+			return exactMatch
+		}
+
+		if hasWildcard && name == wildcard {
+			result = wildcardMatch
+		}
+	}
+
+	return result
+}
+
+// bestCandidate returns the candidate to use for the domain and the reason it
+// was chosen. Exact names are preferred over wildcards, between equal matches
+// the selection decides. When a key type is given only certificates with that
+// key type are considered.
+func bestCandidate(candidates map[string]*candidate, domain string, keyType string, selection *Selection) (*candidate, string) {
+	// The candidates are compared in a fixed order, so the outcome doesn't
+	// depend on the order of the map.
+	keys := make([]string, 0, len(candidates))
+	for key := range candidates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// The runner-up is kept to explain the choice against the candidate which
+	// came closest, instead of against whichever candidate was compared last.
+	var best, runnerUp *candidate
+	bestMatch := noMatch
+	for _, key := range keys {
+		c := candidates[key]
+		if keyType != anyKeyType && c.keyType != keyType {
+			continue
+		}
+
+		match := c.match(domain)
+		if match == noMatch || match < bestMatch {
+			continue
+		}
+
+		if match > bestMatch {
+			best, runnerUp = c, nil
+			bestMatch = match
+			continue
+		}
+
+		if result, _ := selection.compare(c, best); result > 0 {
+			best, runnerUp = c, best
+		} else if runnerUp == nil {
+			runnerUp = c
+		} else if result, _ := selection.compare(c, runnerUp); result > 0 {
+			runnerUp = c
+		}
+	}
+
+	if best == nil {
+		return nil, ""
+	}
+
+	reason := "only candidate"
+	if runnerUp != nil {
+		if result, criterion := selection.compare(best, runnerUp); result > 0 {
+			reason = criterion
+		} else {
+			reason = "tie, first candidate kept"
+		}
+	}
+
+	if bestMatch == exactMatch {
+		return best, "exact match, " + reason
+	}
+
+	return best, "wildcard match, " + reason
+}
@@ -0,0 +1,155 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"sync"
+)
+
+var (
+	errQueueStopped = errors.New("tracker stopped before invocation was handed to subscriber")
+	errSuperseded   = errors.New("invocation superseded by a later invocation")
+)
+
+// queues holds a queue per subscriber channel.
+type queues struct {
+	lock    sync.Mutex
+	queues  map[chan<- subscriber.Invocation]*queue
+	stopped bool
+}
+
+// queue hands the invocations of a subscriber to its channel. The tracker
+// never waits for a subscriber, so a subscriber which doesn't take
+// invocations, e.g. while it retries or restarts, doesn't hold up the tracker
+// and the other subscribers. A queued invocation is replaced by a later
+// invocation for the same target, domain and key type.
+type queue struct {
+	channel chan<- subscriber.Invocation
+	input   chan subscriber.Invocation
+	flush   chan chan struct{}
+	stop    chan struct{}
+	pending []subscriber.Invocation
+}
+
+func newQueues() *queues {
+	return &queues{queues: map[chan<- subscriber.Invocation]*queue{}}
+}
+
+// push queues the invocation for the channel, once stopped the invocation
+// fails instead.
+func (q *queues) push(channel chan<- subscriber.Invocation, invocation subscriber.Invocation) {
+	q.lock.Lock()
+	if q.stopped {
+		q.lock.Unlock()
+		invocation.Report(errQueueStopped)
+		return
+	}
+
+	subscriberQueue, ok := q.queues[channel]
+	if !ok {
+		subscriberQueue = &queue{
+			channel: channel,
+			input:   make(chan subscriber.Invocation),
+			flush:   make(chan chan struct{}),
+			stop:    make(chan struct{}),
+		}
+		q.queues[channel] = subscriberQueue
+		go subscriberQueue.run()
+	}
+	q.lock.Unlock()
+
+	// The queue always takes input, unless it is stopped concurrently.
+	select {
+	case subscriberQueue.input <- invocation:
+	case <-subscriberQueue.stop:
+		invocation.Report(errQueueStopped)
+	}
+}
+
+// flush waits until every queued invocation is handed to its subscriber and
+// then stops the queues, also when the context is done first.
+func (q *queues) flush(ctx context.Context) error {
+	q.lock.Lock()
+	if q.stopped {
+		q.lock.Unlock()
+		return nil
+	}
+	q.stopped = true
+	queues := q.queues
+	q.lock.Unlock()
+
+	defer func() {
+		for _, subscriberQueue := range queues {
+			close(subscriberQueue.stop)
+		}
+	}()
+
+	for _, subscriberQueue := range queues {
+		flushed := make(chan struct{})
+
+		select {
+		case subscriberQueue.flush <- flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (q *queue) run() {
+	var flushed []chan struct{}
+
+	for {
+		if len(q.pending) == 0 {
+			for _, done := range flushed {
+				close(done)
+			}
+			flushed = nil
+		}
+
+		// Only offer an invocation when there is one.
+		var channel chan<- subscriber.Invocation
+		var next subscriber.Invocation
+		if len(q.pending) > 0 {
+			channel = q.channel
+			next = q.pending[0]
+		}
+
+		select {
+		case invocation := <-q.input:
+			q.add(invocation)
+		case channel <- next:
+			q.pending = q.pending[1:]
+		case done := <-q.flush:
+			flushed = append(flushed, done)
+		case <-q.stop:
+			for _, invocation := range q.pending {
+				invocation.Report(errQueueStopped)
+			}
+			return
+		}
+	}
+}
+
+func (q *queue) add(invocation subscriber.Invocation) {
+	for i, queued := range q.pending {
+		if queued.SubscriberName == invocation.SubscriberName &&
+			queued.Target == invocation.Target &&
+			queued.Domain == invocation.Domain &&
+			queued.KeyType == invocation.KeyType {
+			queued.Report(errSuperseded)
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			break
+		}
+	}
+
+	q.pending = append(q.pending, invocation)
+}
@@ -0,0 +1,121 @@
+package tracking
+
+import (
+	"context"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestQueueDoesNotBlock(t *testing.T) {
+	q := newQueues()
+	channel := make(chan subscriber.Invocation)
+	results := make(chan subscriber.Result, 10)
+
+	pushed := make(chan struct{})
+	go func() {
+		for _, domain := range []string{"a.example.com", "b.example.com", "a.example.com"} {
+			q.push(channel, subscriber.Invocation{Target: "target", Domain: domain, Results: results})
+		}
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push blocked on a subscriber which doesn't read")
+	}
+
+	var domains []string
+	for i := 0; i < 2; i++ {
+		domains = append(domains, (<-channel).Domain)
+	}
+
+	if domains[0] != "b.example.com" || domains[1] != "a.example.com" {
+		t.Errorf("unexpected invocations %v, expected the queued invocation to be replaced", domains)
+	}
+
+	select {
+	case result := <-results:
+		if result.Error != errSuperseded || result.Invocation.Domain != "a.example.com" {
+			t.Errorf("unexpected result %v for %s", result.Error, result.Invocation.Domain)
+		}
+	case <-time.After(time.Second):
+		t.Error("replaced invocation not reported")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := q.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueFlushFailsPending(t *testing.T) {
+	q := newQueues()
+	channel := make(chan subscriber.Invocation)
+	results := make(chan subscriber.Result, 10)
+
+	q.push(channel, subscriber.Invocation{Domain: "a.example.com", Results: results})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.flush(ctx); err == nil {
+		t.Fatal("flush succeeded while the subscriber didn't read")
+	}
+
+	// Invocations pushed after the queues stopped fail as well.
+	q.push(channel, subscriber.Invocation{Domain: "b.example.com", Results: results})
+
+	failed := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case result := <-results:
+			if result.Error != errQueueStopped {
+				t.Errorf("unexpected result %v for %s", result.Error, result.Invocation.Domain)
+			}
+			failed[result.Invocation.Domain] = true
+		case <-time.After(time.Second):
+			t.Fatalf("invocations not failed, only %v", failed)
+		}
+	}
+
+	if !failed["a.example.com"] || !failed["b.example.com"] {
+		t.Errorf("unexpected failed invocations %v", failed)
+	}
+}
+
+func TestReportAfterTrackerStopped(t *testing.T) {
+	tracker := NewTracker()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	channel := make(chan subscriber.Invocation, 10)
+	tracker.addSubscription(subscriber.Message{
+		SubscriberName: "test",
+		Domains:        []string{"a.example.com"},
+		Channel:        channel,
+	}, ctx)
+	tracker.certificateChanged(changedCertificate{source: "test", certificate: newTestCertificate(t, "a.example.com")}, ctx)
+	invocation := receive(t, channel, 1)["a.example.com"]
+
+	cancel()
+	if err := tracker.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// More results than fit in the buffer of the tracker, without the tracker
+	// receiving them their goroutines would block forever.
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 2*cap(tracker.resultsChan); i++ {
+		invocation.Report(nil)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still reporting", runtime.NumGoroutine()-goroutines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,162 @@
+package tracking
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	LatestNotAfter   = "latest_not_after"
+	PreferredIssuer  = "preferred_issuer"
+	PreferredKeyType = "preferred_key_type"
+	WatcherPriority  = "watcher_priority"
+)
+
+// Selection decides which certificate is used when multiple certificates
+// cover a domain equally well. Whatever the policy, ties are broken by the
+// latest expiry and then by the most recently received certificate.
+type Selection struct {
+	Policy   string   `description:"Policy, latest_not_after, preferred_issuer, preferred_key_type or watcher_priority" json:"policy" yaml:"policy"`
+	Issuer   string   `description:"Issuer to prefer, matched against the issuer common name and organization" json:"issuer" yaml:"issuer"`
+	KeyType  string   `description:"Key type to prefer, ecdsa, rsa or ed25519" json:"key_type" yaml:"key_type"`
+	Watchers []string `description:"Watchers in order of preference" json:"watchers" yaml:"watchers"`
+}
+
+// SelectionPolicy holds the global selection and overrides for single domains.
+type SelectionPolicy struct {
+	Selection `yaml:",inline"`
+	Domains   map[string]Selection `description:"Selection per domain" json:"domains" yaml:"domains"`
+}
+
+func (p *SelectionPolicy) Init() error {
+	if err := p.Selection.validate(); err != nil {
+		return err
+	}
+
+	domains := map[string]Selection{}
+	for domain, selection := range p.Domains {
+		if err := selection.validate(); err != nil {
+			return errors.New(domain + ": " + err.Error())
+		}
+
+		normalized, ok := normalizeDomain(domain)
+		if !ok {
+			return errors.New("invalid domain " + domain)
+		}
+
+		domains[normalized] = selection
+	}
+
+	p.Domains = domains
+
+	return nil
+}
+
+func (p *SelectionPolicy) forDomain(domain string) *Selection {
+	if p == nil {
+		return &Selection{}
+	}
+
+	if selection, ok := p.Domains[domain]; ok {
+		return &selection
+	}
+
+	return &p.Selection
+}
+
+func (s *Selection) validate() error {
+	switch s.Policy {
+	case "", LatestNotAfter:
+	case PreferredIssuer:
+		if s.Issuer == "" {
+			return errors.New("policy preferred_issuer requires an issuer")
+		}
+	case PreferredKeyType:
+		if s.KeyType == "" {
+			return errors.New("policy preferred_key_type requires a key_type")
+		}
+	case WatcherPriority:
+		if len(s.Watchers) == 0 {
+			return errors.New("policy watcher_priority requires watchers")
+		}
+	default:
+		return errors.New("unknown selection policy " + s.Policy)
+	}
+
+	return nil
+}
+
+// compare returns a positive number if a is preferred over b, a negative
+// number if b is preferred and zero if they're equal. The returned reason
+// describes the criterion which made the difference.
+func (s *Selection) compare(a *candidate, b *candidate) (int, string) {
+	switch s.Policy {
+	case PreferredIssuer:
+		if result := compareBool(s.issuerMatches(a), s.issuerMatches(b)); result != 0 {
+			return result, "preferred issuer"
+		}
+	case PreferredKeyType:
+		if result := compareBool(a.keyType == strings.ToLower(s.KeyType), b.keyType == strings.ToLower(s.KeyType)); result != 0 {
+			return result, "preferred key type"
+		}
+	case WatcherPriority:
+		if result := s.watcherPriority(b.source) - s.watcherPriority(a.source); result != 0 {
+			return result, "watcher priority"
+		}
+	}
+
+	if a.notAfter.After(b.notAfter) {
+		return 1, "latest expiry"
+	} else if b.notAfter.After(a.notAfter) {
+		return -1, "latest expiry"
+	}
+
+	if a.sequence > b.sequence {
+		return 1, "most recently received"
+	} else if b.sequence > a.sequence {
+		return -1, "most recently received"
+	}
+
+	return 0, ""
+}
+
+func (s *Selection) issuerMatches(c *candidate) bool {
+	if c.leaf == nil {
+		return false
+	}
+
+	issuer := strings.ToLower(s.Issuer)
+	if strings.Contains(strings.ToLower(c.leaf.Issuer.CommonName), issuer) {
+		return true
+	}
+
+	for _, organization := range c.leaf.Issuer.Organization {
+		if strings.Contains(strings.ToLower(organization), issuer) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watcherPriority returns the position of the watcher in the preference list,
+// watchers which aren't listed come last.
+func (s *Selection) watcherPriority(source string) int {
+	for i, watcher := range s.Watchers {
+		if watcher == source {
+			return i
+		}
+	}
+
+	return len(s.Watchers)
+}
+
+func compareBool(a bool, b bool) int {
+	if a == b {
+		return 0
+	} else if a {
+		return 1
+	}
+
+	return -1
+}
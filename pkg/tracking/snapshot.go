@@ -0,0 +1,221 @@
+package tracking
+
+import (
+	"context"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot is a consistent copy of the state of the tracker.
+type Snapshot struct {
+	Certificates []CertificateInfo `json:"certificates"`
+	Wildcards    []string          `json:"wildcards"`
+	Domains      []DomainInfo      `json:"domains"`
+	Subscribers  []SubscriberInfo  `json:"subscribers"`
+	Deliveries   []Delivery        `json:"deliveries"`
+}
+
+// CertificateInfo describes a certificate provided by a watcher.
+type CertificateInfo struct {
+	Source       string            `json:"source"`
+	Provider     string            `json:"provider,omitempty"`
+	Names        []string          `json:"names"`
+	KeyType      string            `json:"key_type"`
+	Issuer       string            `json:"issuer"`
+	NotAfter     time.Time         `json:"not_after"`
+	Fingerprints cert.Fingerprints `json:"fingerprints"`
+}
+
+// DomainInfo describes a subscribed domain and the certificates chosen for it.
+type DomainInfo struct {
+	Domain       string                `json:"domain"`
+	Certificates []SelectedCertificate `json:"certificates"`
+	Subscribers  []string              `json:"subscribers"`
+	Served       map[string]bool       `json:"served"`
+	LastDelivery time.Time             `json:"last_delivery"`
+}
+
+// SelectedCertificate is the certificate chosen for a domain and requested key
+// type, an empty RequestedKeyType means any key type.
+type SelectedCertificate struct {
+	RequestedKeyType string            `json:"requested_key_type"`
+	KeyType          string            `json:"key_type"`
+	Source           string            `json:"source"`
+	Provider         string            `json:"provider,omitempty"`
+	Reason           string            `json:"reason"`
+	Fallback         bool              `json:"fallback"`
+	NotAfter         time.Time         `json:"not_after"`
+	Fingerprints     cert.Fingerprints `json:"fingerprints"`
+}
+
+// SubscriberInfo describes a subscription.
+type SubscriberInfo struct {
+	Name     string   `json:"name"`
+	Target   string   `json:"target"`
+	Domains  []string `json:"domains"`
+	KeyTypes []string `json:"key_types"`
+}
+
+// Delivery is the last invocation of a subscriber for a domain and key type.
+type Delivery struct {
+	Subscriber  string     `json:"subscriber"`
+	Target      string     `json:"target"`
+	Domain      string     `json:"domain"`
+	KeyType     string     `json:"key_type"`
+	Action      string     `json:"action"`
+	Fingerprint string     `json:"fingerprint"`
+	Started     time.Time  `json:"started"`
+	Finished    *time.Time `json:"finished,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Pending reports whether the subscriber didn't report the result yet.
+func (d *Delivery) Pending() bool {
+	return d.Finished == nil
+}
+
+// Snapshot returns a copy of the current state. The copy is made by the
+// tracker goroutine, so it is safe to call from any goroutine.
+func (t *Tracker) Snapshot(ctx context.Context) (*Snapshot, error) {
+	response := make(chan *Snapshot, 1)
+
+	select {
+	case t.snapshotChan <- response:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case snapshot := <-response:
+		return snapshot, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *Tracker) snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		Certificates: []CertificateInfo{},
+		Wildcards:    []string{},
+		Domains:      []DomainInfo{},
+		Subscribers:  []SubscriberInfo{},
+		Deliveries:   []Delivery{},
+	}
+
+	wildcards := map[string]bool{}
+	for _, c := range t.certificates {
+		info := CertificateInfo{
+			Source:       c.source,
+			Provider:     c.provider,
+			Names:        append([]string{}, c.names...),
+			KeyType:      c.keyType,
+			NotAfter:     c.notAfter,
+			Fingerprints: c.certificate.Fingerprints(),
+		}
+
+		if c.leaf != nil {
+			info.Issuer = c.leaf.Issuer.CommonName
+		}
+
+		snapshot.Certificates = append(snapshot.Certificates, info)
+
+		for _, name := range c.names {
+			if strings.HasPrefix(name, "*.") && !wildcards[name] {
+				wildcards[name] = true
+				snapshot.Wildcards = append(snapshot.Wildcards, name)
+			}
+		}
+	}
+
+	subscribers := map[string]*SubscriberInfo{}
+	var subscriberKeys []string
+	for _, item := range t.items {
+		info := DomainInfo{
+			Domain:       item.domain,
+			Certificates: []SelectedCertificate{},
+			Subscribers:  []string{},
+			Served:       map[string]bool{},
+			LastDelivery: item.lastDelivery,
+		}
+
+		for _, s := range item.slots {
+			if s.certificate == nil {
+				continue
+			}
+
+			info.Certificates = append(info.Certificates, SelectedCertificate{
+				RequestedKeyType: s.keyType,
+				KeyType:          s.actualType,
+				Source:           s.source,
+				Provider:         s.provider,
+				Reason:           s.reason,
+				Fallback:         s.fallback,
+				NotAfter:         s.notAfter,
+				Fingerprints:     s.fingerprints,
+			})
+		}
+
+		for endpoint, matches := range item.served {
+			info.Served[endpoint] = matches
+		}
+
+		for _, subscr := range item.subscribers {
+			key := SubscriberKey(subscr.SubscriberName, subscr.Target)
+			info.Subscribers = append(info.Subscribers, key)
+
+			if _, ok := subscribers[key]; !ok {
+				subscribers[key] = &SubscriberInfo{
+					Name:     subscr.SubscriberName,
+					Target:   subscr.Target,
+					Domains:  []string{},
+					KeyTypes: append([]string{}, subscr.KeyTypes...),
+				}
+				subscriberKeys = append(subscriberKeys, key)
+			}
+
+			subscribers[key].Domains = append(subscribers[key].Domains, item.domain)
+		}
+
+		for _, delivery := range item.deliveries {
+			snapshot.Deliveries = append(snapshot.Deliveries, *delivery)
+		}
+
+		sort.Slice(info.Certificates, func(i, j int) bool {
+			return info.Certificates[i].RequestedKeyType < info.Certificates[j].RequestedKeyType
+		})
+		snapshot.Domains = append(snapshot.Domains, info)
+	}
+
+	sort.Strings(subscriberKeys)
+	for _, key := range subscriberKeys {
+		sort.Strings(subscribers[key].Domains)
+		snapshot.Subscribers = append(snapshot.Subscribers, *subscribers[key])
+	}
+
+	sort.Strings(snapshot.Wildcards)
+	sort.Slice(snapshot.Certificates, func(i, j int) bool {
+		a, b := snapshot.Certificates[i], snapshot.Certificates[j]
+		return strings.Join(a.Names, ",")+a.Source+a.KeyType < strings.Join(b.Names, ",")+b.Source+b.KeyType
+	})
+	sort.Slice(snapshot.Domains, func(i, j int) bool {
+		return snapshot.Domains[i].Domain < snapshot.Domains[j].Domain
+	})
+	sort.Slice(snapshot.Deliveries, func(i, j int) bool {
+		a, b := snapshot.Deliveries[i], snapshot.Deliveries[j]
+		return a.Domain+a.Subscriber+a.Target+a.KeyType < b.Domain+b.Subscriber+b.Target+b.KeyType
+	})
+
+	return snapshot
+}
+
+// SubscriberKey identifies the target of a subscriber, it is the name of the
+// subscriber followed by the target when the subscriber has multiple targets.
+func SubscriberKey(name string, target string) string {
+	if target == "" {
+		return name
+	}
+
+	return name + "/" + target
+}
@@ -1,116 +1,381 @@
 package tracking
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
 	"github.com/RobertMe/cert-watcher/pkg/cert"
 	"github.com/RobertMe/cert-watcher/pkg/subscriber"
 	"github.com/rs/zerolog/log"
-	"strings"
+	"time"
 )
 
-type wildcard struct {
-	domains []string
+// Issuer creates certificates for domains requested by subscribers for which
+// no watcher provides a certificate. The issued certificates are replaced as
+// soon as a watcher provides a certificate for the domain.
+type Issuer interface {
+	Issue(domain string, keyType string) (*cert.Certificate, error)
+}
+
+// servedGracePeriod is the time subscribers get to apply a delivered
+// certificate before an endpoint serving another certificate is reported.
+const servedGracePeriod = 2 * time.Minute
+
+type changedCertificate struct {
+	source      string
+	provider    string
+	certificate *cert.Certificate
+	removed     bool
+}
+
+type servedCertificate struct {
+	endpoint    string
 	certificate *cert.Certificate
 }
 
 type Tracker struct {
-	items map[string]*item
+	items        map[string]*item
+	certificates map[string]*candidate
+	sequence     uint64
 
-	wildcards map[string]*wildcard
-	unmatchedSubscribers  map[string]*item
+	fallbackIssuer Issuer
+	selection      *SelectionPolicy
+	queues         *queues
 
-	certificateChangedChan chan *cert.Certificate
-	addSubscriptionChan chan subscriber.Message
+	certificateChangedChan chan changedCertificate
+	certificateServedChan  chan servedCertificate
+	addSubscriptionChan    chan subscriber.Message
+	resultsChan            chan subscriber.Result
+	done                   chan struct{}
+	snapshotChan           chan chan *Snapshot
+	deliverChan            chan deliverRequest
+	chooseChan             chan chooseRequest
 }
 
 func NewTracker() *Tracker {
 	return &Tracker{
-		items:     make(map[string]*item),
-
-		wildcards: make(map[string]*wildcard),
-		unmatchedSubscribers: make(map[string]*item),
+		items:        make(map[string]*item),
+		certificates: make(map[string]*candidate),
+		queues:       newQueues(),
 
-		certificateChangedChan: make(chan *cert.Certificate, 100),
-		addSubscriptionChan: make(chan subscriber.Message, 100),
+		certificateChangedChan: make(chan changedCertificate, 100),
+		certificateServedChan:  make(chan servedCertificate, 100),
+		addSubscriptionChan:    make(chan subscriber.Message, 100),
+		resultsChan:            make(chan subscriber.Result, 100),
+		done:                   make(chan struct{}),
+		snapshotChan:           make(chan chan *Snapshot),
+		deliverChan:            make(chan deliverRequest),
+		chooseChan:             make(chan chooseRequest),
 	}
 }
 
-func (t *Tracker) Start(parentCtx context.Context) {
-	logger := log.Logger.With().Str("component", "tracker").Logger()
+// SetFallbackIssuer configures the issuer used for domains without a
+// certificate, its certificates are renewed after two thirds of their validity.
+func (t *Tracker) SetFallbackIssuer(issuer Issuer) {
+	t.fallbackIssuer = issuer
+}
+
+// SetSelectionPolicy configures how a certificate is chosen when multiple
+// certificates cover a domain.
+func (t *Tracker) SetSelectionPolicy(selection *SelectionPolicy) {
+	t.selection = selection
+}
+
+// Run handles the changes until the context is done. Changes and results
+// queued at that moment are still handled, so every invocation resulting from
+// them is handed to the subscribers before Run returns. Results reported
+// afterwards are dropped.
+func (t *Tracker) Run(parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("component", "tracker").Logger()
 	ctx := logger.WithContext(parentCtx)
-	go func() {
-		for {
-			select {
-			case certificate := <- t.certificateChangedChan:
-				t.certificateChanged(certificate, ctx)
-			case message := <- t.addSubscriptionChan:
-				switch message.Action {
-				case subscriber.AddSubscriber:
-					t.addSubscription(message, ctx)
-					break
-				}
-			}
+	defer close(t.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Draining queued changes")
+			t.drain(ctx)
+			logger.Info().Msg("Stopped tracker")
+			return nil
+		case <-ticker.C:
+			t.renewFallbackCertificates()
+		case changed := <-t.certificateChangedChan:
+			t.certificateChanged(changed, ctx)
+		case served := <-t.certificateServedChan:
+			t.certificateServed(served, ctx)
+		case result := <-t.resultsChan:
+			t.deliveryFinished(result)
+		case response := <-t.snapshotChan:
+			response <- t.snapshot()
+		case request := <-t.deliverChan:
+			request.response <- t.deliver(request.target)
+		case request := <-t.chooseChan:
+			request.response <- t.choose(request.domains, request.keyTypes)
+		case message := <-t.addSubscriptionChan:
+			t.subscriptionChanged(message, ctx)
+		}
+	}
+}
+
+// Flush waits until the invocations queued for the subscribers are handed to
+// them, it is called once Run returned. Afterwards invocations fail right away.
+func (t *Tracker) Flush(ctx context.Context) error {
+	return t.queues.flush(ctx)
+}
+
+func (t *Tracker) drain(ctx context.Context) {
+	for {
+		select {
+		case changed := <-t.certificateChangedChan:
+			t.certificateChanged(changed, ctx)
+		case served := <-t.certificateServedChan:
+			t.certificateServed(served, ctx)
+		case result := <-t.resultsChan:
+			t.deliveryFinished(result)
+		case message := <-t.addSubscriptionChan:
+			t.subscriptionChanged(message, ctx)
+		default:
+			return
 		}
-	}()
+	}
 }
 
-func (t *Tracker) CertificateChanged(certificate *cert.Certificate) {
-	t.certificateChangedChan <- certificate
+// CertificateChanged adds or replaces the certificate provided by the provider
+// of the source watcher, after which every subscribed domain is matched again.
+func (t *Tracker) CertificateChanged(source string, provider string, certificate *cert.Certificate) {
+	t.certificateChangedChan <- changedCertificate{
+		source:      source,
+		provider:    provider,
+		certificate: certificate,
+	}
+}
+
+// CertificateRemoved withdraws the certificate provided by the provider of the
+// source watcher. Subscribers of domains left without certificate are notified
+// of the removal.
+func (t *Tracker) CertificateRemoved(source string, provider string, certificate *cert.Certificate) {
+	t.certificateChangedChan <- changedCertificate{
+		source:      source,
+		provider:    provider,
+		certificate: certificate,
+		removed:     true,
+	}
+}
+
+// CertificateServed compares the certificate served by an endpoint to the
+// certificate delivered for the domain it was requested for.
+func (t *Tracker) CertificateServed(endpoint string, certificate *cert.Certificate) {
+	t.certificateServedChan <- servedCertificate{
+		endpoint:    endpoint,
+		certificate: certificate,
+	}
 }
 
 func (t *Tracker) AddSubscription(message subscriber.Message) {
 	t.addSubscriptionChan <- message
 }
 
-func (t *Tracker) certificateChanged(certificate *cert.Certificate, ctx context.Context) {
+func (t *Tracker) subscriptionChanged(message subscriber.Message, ctx context.Context) {
+	switch message.Action {
+	case subscriber.AddSubscriber:
+		t.addSubscription(message, ctx)
+	}
+}
+
+func (t *Tracker) deliveryFinished(result subscriber.Result) {
+	if item, ok := t.items[result.Invocation.Domain]; ok {
+		item.deliveryFinished(result)
+	}
+}
+
+func (t *Tracker) certificateChanged(changed changedCertificate, ctx context.Context) {
 	logger := log.Ctx(ctx)
-	logger.Info().Strs("names", certificate.Names).Msg("Handling changed certificate")
-	for _, name := range certificate.Names {
-		if strings.HasPrefix(name, "*.") {
-			if wildcrd, ok := t.wildcards[name]; ok {
-				for _, domain := range wildcrd.domains {
-					t.items[domain].updateCertificate(certificate)
-				}
-			} else {
-				t.wildcards[name] = &wildcard{
-					domains: []string{},
-					certificate: certificate,
-				}
+	logger.Info().
+		Str("source", changed.source).
+		Str("provider", changed.provider).
+		Strs("names", changed.certificate.Names).
+		Bool("removed", changed.removed).
+		Msg("Handling changed certificate")
+
+	t.sequence++
+	c := newCandidate(changed.source, changed.provider, changed.certificate, t.sequence)
+	if len(c.names) == 0 {
+		logger.Error().Strs("names", changed.certificate.Names).Msg("Ignoring certificate without valid names")
+		return
+	}
+
+	if changed.removed {
+		if _, ok := t.certificates[c.key()]; !ok {
+			logger.Debug().Strs("names", c.names).Msg("Removed certificate wasn't tracked")
+			return
+		}
+
+		delete(t.certificates, c.key())
+	} else {
+		t.certificates[c.key()] = c
+	}
+
+	t.evaluate()
+}
+
+// evaluate matches every subscribed domain against the current set of
+// certificates, updating the items for which another certificate is chosen.
+func (t *Tracker) evaluate() {
+	for _, item := range t.items {
+		t.evaluateItem(item)
+	}
+}
+
+func (t *Tracker) evaluateItem(item *item) {
+	selection := t.selection.forDomain(item.domain)
+	for keyType, s := range item.slots {
+		if best, reason := bestCandidate(t.certificates, item.domain, keyType, selection); best != nil {
+			item.selectCandidate(s, best, reason)
+			continue
+		}
+
+		if s.fallback {
+			continue
+		}
+
+		if t.fallbackIssuer != nil {
+			err := item.updateFallbackCertificate(s, t.fallbackIssuer)
+			if err == nil {
+				continue
+			}
+
+			s.logger.Error().Err(err).Msg("Failed issuing fallback certificate")
+		}
+
+		if s.certificate != nil {
+			item.removeCertificate(s)
+		}
+	}
+}
+
+func (t *Tracker) certificateServed(served servedCertificate, ctx context.Context) {
+	logger := log.Ctx(ctx).With().
+		Str("endpoint", served.endpoint).
+		Strs("names", served.certificate.Names).
+		Logger()
+
+	if len(served.certificate.Names) == 0 {
+		return
+	}
+
+	domain, ok := normalizeDomain(served.certificate.Names[0])
+	if !ok {
+		return
+	}
+
+	item, subscribed := t.items[domain]
+
+	var expected []*cert.Certificate
+	if subscribed {
+		for _, s := range item.slots {
+			if s.certificate != nil {
+				expected = append(expected, s.certificate)
 			}
-		} else if item, ok := t.items[name]; ok {
-			item.updateCertificate(certificate)
-		} else {
-			item := newItem(name, logger)
-			item.certificate = certificate
-			t.items[name] = item
 		}
+	} else if best, _ := bestCandidate(t.certificates, domain, anyKeyType, t.selection.forDomain(domain)); best != nil {
+		expected = append(expected, best.certificate)
+	}
+
+	if len(expected) == 0 {
+		logger.Debug().Msg("No certificate tracked for served certificate")
+		return
+	}
+
+	leaf, err := served.certificate.Leaf()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed parsing served certificate")
+		return
+	}
+
+	matches := false
+	var current *x509.Certificate
+	for _, certificate := range expected {
+		if current, err = certificate.Leaf(); err != nil {
+			logger.Error().Err(err).Msg("Failed parsing tracked certificate")
+			return
+		}
+
+		if bytes.Equal(current.Raw, leaf.Raw) {
+			matches = true
+			break
+		}
+	}
+
+	if subscribed {
+		item.served[served.endpoint] = matches
+	}
+
+	if matches {
+		logger.Debug().Msg("Endpoint serves the current certificate")
+		return
+	}
+
+	logger = logger.With().
+		Time("served_not_after", leaf.NotAfter).
+		Time("current_not_after", current.NotAfter).
+		Logger()
+
+	if !subscribed || len(item.subscribers) == 0 || time.Since(item.lastDelivery) < servedGracePeriod {
+		logger.Debug().Msg("Endpoint serves another certificate")
+		return
 	}
+
+	logger.Warn().Time("delivered_at", item.lastDelivery).Msg("Endpoint still serves an outdated certificate after delivery")
 }
 
 func (t *Tracker) addSubscription(message subscriber.Message, ctx context.Context) {
 	logger := log.Ctx(ctx)
 	logger.Info().Strs("domains", message.Domains).Msg("Adding subscription")
-	for _, domain := range message.Domains {
-		if item, ok := t.items[domain]; ok {
-			item.addSubscriber(message)
 
-			return
+	subscribed := map[string]bool{}
+	for _, requested := range message.Domains {
+		domain, ok := normalizeDomain(requested)
+		if !ok {
+			logger.Error().
+				Str("domain", requested).
+				Str("subscriber", message.SubscriberName).
+				Msg("Ignoring invalid domain in subscription")
+			continue
 		}
 
-		wildcardName := "*" + domain[strings.Index(domain, "."):]
+		if subscribed[domain] {
+			continue
+		}
+		subscribed[domain] = true
 
-		wildcrd, ok := t.wildcards[wildcardName]
-		logger.Debug().Bool("wildcard_found", ok).Str("wildcard_name", wildcardName).Msg("Checked wildcard")
+		item, ok := t.items[domain]
 		if !ok {
-			wildcrd = &wildcard{}
-			t.wildcards[wildcardName] = wildcrd
+			item = newItem(domain, t.resultsChan, t.done, t.queues, logger)
+			t.items[domain] = item
 		}
 
-		wildcrd.domains = append(wildcrd.domains, domain)
-
-		item := newItem(domain, logger)
-		t.items[domain] = item
-		item.certificate = wildcrd.certificate
 		item.addSubscriber(message)
+		t.evaluateItem(item)
+	}
+}
+
+func (t *Tracker) renewFallbackCertificates() {
+	if t.fallbackIssuer == nil {
+		return
+	}
+
+	for _, item := range t.items {
+		for _, s := range item.slots {
+			if !s.fallbackExpiring() {
+				continue
+			}
+
+			if err := item.updateFallbackCertificate(s, t.fallbackIssuer); err != nil {
+				s.logger.Error().Err(err).Msg("Failed renewing fallback certificate")
+			}
+		}
 	}
 }
@@ -0,0 +1,413 @@
+package tracking
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/subscriber"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, names ...string) *cert.Certificate {
+	t.Helper()
+
+	return newTestKeyCertificate(t, "ec256", names...)
+}
+
+func newTestKeyCertificate(t *testing.T, algorithm string, names ...string) *cert.Certificate {
+	t.Helper()
+
+	key, err := cert.GenerateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPem, err := cert.EncodeKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &cert.Certificate{
+		Names: names,
+		Cert:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:   keyPem,
+	}
+}
+
+// receive returns the invocations handed to the channel, keyed by domain, and
+// fails when another number of invocations is received.
+func receive(t *testing.T, channel <-chan subscriber.Invocation, count int) map[string]subscriber.Invocation {
+	t.Helper()
+
+	invocations := map[string]subscriber.Invocation{}
+	for i := 0; i < count; i++ {
+		select {
+		case invocation := <-channel:
+			invocations[invocation.Domain] = invocation
+		case <-time.After(time.Second):
+			t.Fatalf("received %d invocations, expected %d", i, count)
+		}
+	}
+
+	select {
+	case invocation := <-channel:
+		t.Fatalf("unexpected invocation for %s", invocation.Domain)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	return invocations
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain     string
+		normalized string
+		valid      bool
+	}{
+		{"a.example.com", "a.example.com", true},
+		{" A.Example.COM. ", "a.example.com", true},
+		{"localhost", "localhost", true},
+		{"*.example.com", "*.example.com", true},
+		{"_acme-challenge.example.com", "_acme-challenge.example.com", true},
+		{"", "", false},
+		{"*", "*", false},
+		{"a.*.example.com", "", false},
+		{"*a.example.com", "", false},
+		{"a..example.com", "", false},
+		{"-a.example.com", "", false},
+		{"a b.example.com", "", false},
+		{strings.Repeat("a", 64) + ".example.com", "", false},
+	}
+
+	for _, test := range tests {
+		normalized, valid := normalizeDomain(test.domain)
+		if valid != test.valid {
+			t.Errorf("normalizeDomain(%q) valid = %t, expected %t", test.domain, valid, test.valid)
+		}
+
+		if test.valid && normalized != test.normalized {
+			t.Errorf("normalizeDomain(%q) = %q, expected %q", test.domain, normalized, test.normalized)
+		}
+	}
+}
+
+func TestCandidateMatch(t *testing.T) {
+	tests := []struct {
+		names  []string
+		domain string
+		match  int
+	}{
+		{[]string{"a.example.com"}, "a.example.com", exactMatch},
+		{[]string{"*.example.com"}, "a.example.com", wildcardMatch},
+		{[]string{"*.example.com", "a.example.com"}, "a.example.com", exactMatch},
+		{[]string{"*.example.com"}, "example.com", noMatch},
+		{[]string{"*.example.com"}, "a.b.example.com", noMatch},
+		{[]string{"*.example.com"}, "*.example.com", exactMatch},
+		{[]string{"localhost"}, "localhost", exactMatch},
+		{[]string{"*.localhost"}, "localhost", noMatch},
+		{[]string{"b.example.com"}, "a.example.com", noMatch},
+	}
+
+	for _, test := range tests {
+		c := newCandidate("test", "", newTestCertificate(t, test.names...), 1)
+		if match := c.match(test.domain); match != test.match {
+			t.Errorf("%v matching %s = %d, expected %d", test.names, test.domain, match, test.match)
+		}
+	}
+}
+
+func TestAddSubscription(t *testing.T) {
+	tests := []struct {
+		name         string
+		known        []string
+		certificates [][]string
+		domains      []string
+		tracked      []string
+		invoked      map[string][]string
+	}{
+		{
+			name:         "first domain already known",
+			known:        []string{"a.example.com"},
+			certificates: [][]string{{"a.example.com", "b.example.com"}},
+			domains:      []string{"a.example.com", "b.example.com"},
+			tracked:      []string{"a.example.com", "b.example.com"},
+			invoked: map[string][]string{
+				"a.example.com": {"a.example.com", "b.example.com"},
+				"b.example.com": {"a.example.com", "b.example.com"},
+			},
+		},
+		{
+			name:         "single label",
+			certificates: [][]string{{"localhost"}, {"*.example.com"}},
+			domains:      []string{"localhost"},
+			tracked:      []string{"localhost"},
+			invoked:      map[string][]string{"localhost": {"localhost"}},
+		},
+		{
+			name:         "invalid names",
+			certificates: [][]string{{"*.example.com"}},
+			domains:      []string{"a b.example.com", "a..example.com", "*", "b.example.com"},
+			tracked:      []string{"b.example.com"},
+			invoked:      map[string][]string{"b.example.com": {"*.example.com"}},
+		},
+		{
+			name:         "duplicate names",
+			certificates: [][]string{{"a.example.com"}},
+			domains:      []string{"A.example.com", "a.example.com."},
+			tracked:      []string{"a.example.com"},
+			invoked:      map[string][]string{"a.example.com": {"a.example.com"}},
+		},
+		{
+			name:         "wildcard and exact",
+			certificates: [][]string{{"*.example.com"}, {"a.example.com"}},
+			domains:      []string{"a.example.com", "b.example.com", "a.b.example.com", "example.com"},
+			tracked:      []string{"a.b.example.com", "a.example.com", "b.example.com", "example.com"},
+			invoked: map[string][]string{
+				"a.example.com": {"a.example.com"},
+				"b.example.com": {"*.example.com"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := NewTracker()
+			ctx := context.Background()
+
+			for i, names := range test.certificates {
+				tracker.certificateChanged(changedCertificate{
+					source:      "test",
+					provider:    string(rune('a' + i)),
+					certificate: newTestCertificate(t, names...),
+				}, ctx)
+			}
+
+			if len(test.known) > 0 {
+				known := make(chan subscriber.Invocation, 10)
+				tracker.addSubscription(subscriber.Message{
+					SubscriberName: "known",
+					Domains:        test.known,
+					Channel:        known,
+				}, ctx)
+				receive(t, known, len(test.known))
+			}
+
+			channel := make(chan subscriber.Invocation, 10)
+			tracker.addSubscription(subscriber.Message{
+				SubscriberName: "test",
+				Domains:        test.domains,
+				Channel:        channel,
+			}, ctx)
+
+			var tracked []string
+			for domain := range tracker.items {
+				tracked = append(tracked, domain)
+			}
+			sort.Strings(tracked)
+
+			if !reflect.DeepEqual(tracked, test.tracked) {
+				t.Errorf("tracked %v, expected %v", tracked, test.tracked)
+			}
+
+			invocations := receive(t, channel, len(test.invoked))
+			for domain, names := range test.invoked {
+				invocation, ok := invocations[domain]
+				if !ok {
+					t.Errorf("%s not invoked", domain)
+					continue
+				}
+
+				if !reflect.DeepEqual(invocation.Certificate.Names, names) {
+					t.Errorf("%s invoked with %v, expected %v", domain, invocation.Certificate.Names, names)
+				}
+			}
+		})
+	}
+}
+
+func TestCertificateRemovedPerProvider(t *testing.T) {
+	tracker := NewTracker()
+	ctx := context.Background()
+
+	first := newTestCertificate(t, "a.example.com")
+	second := newTestCertificate(t, "a.example.com")
+	tracker.certificateChanged(changedCertificate{source: "traefik", provider: "first", certificate: first}, ctx)
+	tracker.certificateChanged(changedCertificate{source: "traefik", provider: "second", certificate: second}, ctx)
+
+	channel := make(chan subscriber.Invocation, 10)
+	tracker.addSubscription(subscriber.Message{
+		SubscriberName: "test",
+		Domains:        []string{"a.example.com"},
+		Channel:        channel,
+	}, ctx)
+	selected := receive(t, channel, 1)["a.example.com"].Certificate
+
+	removed, kept := first, second
+	if string(selected.Cert) == string(second.Cert) {
+		removed, kept = second, first
+	}
+	provider := map[*cert.Certificate]string{first: "first", second: "second"}
+
+	tracker.certificateChanged(changedCertificate{source: "traefik", provider: provider[removed], certificate: removed, removed: true}, ctx)
+	invocation := receive(t, channel, 1)["a.example.com"]
+	if invocation.Action != subscriber.UpdateCertificate || string(invocation.Certificate.Cert) != string(kept.Cert) {
+		t.Fatalf("expected the certificate of the other provider, got %s", invocation.Action)
+	}
+
+	tracker.certificateChanged(changedCertificate{source: "traefik", provider: provider[kept], certificate: kept, removed: true}, ctx)
+	if invocation := receive(t, channel, 1)["a.example.com"]; invocation.Action != subscriber.RemoveCertificate {
+		t.Fatalf("expected removal, got %s", invocation.Action)
+	}
+}
+
+func TestBestCandidateReason(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		candidates map[string]*candidate
+		best       string
+		reason     string
+	}{
+		{
+			name: "single candidate",
+			candidates: map[string]*candidate{
+				"a": {notAfter: now, sequence: 1},
+			},
+			best:   "a",
+			reason: "exact match, only candidate",
+		},
+		{
+			name: "decided against the runner-up",
+			candidates: map[string]*candidate{
+				"a": {notAfter: later, sequence: 2},
+				"b": {notAfter: later, sequence: 1},
+				"c": {notAfter: now, sequence: 3},
+			},
+			best:   "a",
+			reason: "exact match, most recently received",
+		},
+		{
+			name: "later candidate wins",
+			candidates: map[string]*candidate{
+				"a": {notAfter: now, sequence: 1},
+				"b": {notAfter: now, sequence: 2},
+				"c": {notAfter: later, sequence: 3},
+			},
+			best:   "c",
+			reason: "exact match, latest expiry",
+		},
+		{
+			name: "tie",
+			candidates: map[string]*candidate{
+				"a": {notAfter: later, sequence: 1},
+				"b": {notAfter: now, sequence: 1},
+				"c": {notAfter: later, sequence: 1},
+			},
+			best:   "a",
+			reason: "exact match, tie, first candidate kept",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, c := range test.candidates {
+				c.names = []string{"a.example.com"}
+			}
+
+			best, reason := bestCandidate(test.candidates, "a.example.com", anyKeyType, &Selection{})
+			if best != test.candidates[test.best] {
+				t.Errorf("expected candidate %s to be chosen", test.best)
+			}
+
+			if reason != test.reason {
+				t.Errorf("reason %q, expected %q", reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestKeyTypeSwitch(t *testing.T) {
+	tracker := NewTracker()
+	ctx := context.Background()
+
+	channels := map[string]chan subscriber.Invocation{}
+	for _, keyType := range []string{anyKeyType, "rsa", "ecdsa"} {
+		channels[keyType] = make(chan subscriber.Invocation, 10)
+		message := subscriber.Message{
+			SubscriberName: "test-" + keyType,
+			Domains:        []string{"a.example.com"},
+			Channel:        channels[keyType],
+		}
+		if keyType != anyKeyType {
+			message.KeyTypes = []string{keyType}
+		}
+		tracker.addSubscription(message, ctx)
+	}
+
+	// A resolver switching key types is an update of the new certificate
+	// followed by the removal of the old one.
+	switchTo := func(current *cert.Certificate, previous *cert.Certificate) {
+		tracker.certificateChanged(changedCertificate{source: "traefik", provider: "default", certificate: current}, ctx)
+		if previous != nil {
+			tracker.certificateChanged(changedCertificate{source: "traefik", provider: "default", certificate: previous, removed: true}, ctx)
+		}
+	}
+
+	expect := func(keyType string, action string, certificate *cert.Certificate) {
+		t.Helper()
+
+		if action == "" {
+			receive(t, channels[keyType], 0)
+			return
+		}
+
+		invocation := receive(t, channels[keyType], 1)["a.example.com"]
+		if invocation.Action != action {
+			t.Errorf("%q received %s, expected %s", keyType, invocation.Action, action)
+		}
+		if certificate != nil && string(invocation.Certificate.Cert) != string(certificate.Cert) {
+			t.Errorf("%q received another certificate", keyType)
+		}
+	}
+
+	rsa := newTestKeyCertificate(t, "rsa2048", "a.example.com")
+	switchTo(rsa, nil)
+	expect(anyKeyType, subscriber.UpdateCertificate, rsa)
+	expect("rsa", subscriber.UpdateCertificate, rsa)
+	expect("ecdsa", "", nil)
+
+	ecdsa := newTestKeyCertificate(t, "ec256", "a.example.com")
+	switchTo(ecdsa, rsa)
+	expect(anyKeyType, subscriber.UpdateCertificate, ecdsa)
+	expect("rsa", subscriber.RemoveCertificate, nil)
+	expect("ecdsa", subscriber.UpdateCertificate, ecdsa)
+
+	rsa = newTestKeyCertificate(t, "rsa2048", "a.example.com")
+	switchTo(rsa, ecdsa)
+	expect(anyKeyType, subscriber.UpdateCertificate, rsa)
+	expect("rsa", subscriber.UpdateCertificate, rsa)
+	expect("ecdsa", subscriber.RemoveCertificate, nil)
+}
@@ -0,0 +1,455 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	xacme "golang.org/x/crypto/acme"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	challengeHTTP01    = "http-01"
+	challengeTLSALPN01 = "tls-alpn-01"
+	challengeDNS01     = "dns-01"
+)
+
+type Watcher struct {
+	Directory           string        `description:"URL of the ACME directory" json:"directory" yaml:"directory"`
+	Email               string        `description:"Contact email address of the ACME account" json:"email" yaml:"email"`
+	Domains             []string      `description:"Certificates to obtain, comma separated names per certificate" json:"domains" yaml:"domains"`
+	FromSubscribers     bool          `description:"Obtain certificates for domains requested by subscribers" json:"from_subscribers" yaml:"from_subscribers"`
+	StoragePath         string        `description:"Directory to store the account key and certificates in" json:"storage_path" yaml:"storage_path"`
+	Challenge           string        `description:"Challenge type, http-01, tls-alpn-01 or dns-01" json:"challenge" yaml:"challenge"`
+	HTTPAddress         string        `description:"Address to listen on for http-01 challenges" json:"http_address" yaml:"http_address"`
+	TLSAddress          string        `description:"Address to listen on for tls-alpn-01 challenges" json:"tls_address" yaml:"tls_address"`
+	DNSHook             []string      `description:"Command invoked with present or cleanup, the record name and value for dns-01 challenges" json:"dns_hook" yaml:"dns_hook"`
+	DNSPropagationDelay time.Duration `description:"Time to wait after presenting a dns-01 record" json:"dns_propagation_delay" yaml:"dns_propagation_delay"`
+	KeyType             string        `description:"Certificate key type, ec256, ec384, rsa2048 or rsa4096" json:"key_type" yaml:"key_type"`
+	RenewBefore         time.Duration `description:"Renew certificates this long before they expire" json:"renew_before" yaml:"renew_before"`
+	CheckInterval       time.Duration `description:"Interval to check whether certificates need renewal" json:"check_interval" yaml:"check_interval"`
+	CACertificate       string        `description:"PEM file with the CA certificate(s) to trust for the ACME directory" json:"ca_certificate" yaml:"ca_certificate"`
+
+	certificateChannel chan<- watcher.Message
+
+	requestLock   sync.Mutex
+	requested     []string
+	requestNotify chan struct{}
+
+	client       *xacme.Client
+	certificates map[string]*certificate
+	checked      int32
+
+	challengeLock sync.RWMutex
+	httpTokens    map[string]string
+	tlsCerts      map[string]*tls.Certificate
+}
+
+type certificate struct {
+	names    []string
+	notAfter time.Time
+
+	// Failed certificates are retried with backoff, at retryAt.
+	retryAt time.Time
+	backoff *backoff.ExponentialBackOff
+}
+
+func init() {
+	watcher.Register(watcher.Registration{
+		Type:   "acme",
+		Config: func() interface{} { return &Watcher{} },
+		New: func(config interface{}) (watcher.Watcher, error) {
+			return config.(*Watcher), nil
+		},
+	})
+}
+
+func (w *Watcher) Init() error {
+	if w.Directory == "" {
+		w.Directory = xacme.LetsEncryptURL
+	}
+
+	if w.Challenge == "" {
+		w.Challenge = challengeHTTP01
+	}
+
+	switch w.Challenge {
+	case challengeHTTP01:
+		if w.HTTPAddress == "" {
+			w.HTTPAddress = ":80"
+		}
+	case challengeTLSALPN01:
+		if w.TLSAddress == "" {
+			w.TLSAddress = ":443"
+		}
+	case challengeDNS01:
+		if len(w.DNSHook) == 0 {
+			return errors.New("dns-01 challenge requires a dns_hook")
+		}
+	default:
+		return errors.New("unsupported challenge type " + w.Challenge)
+	}
+
+	if w.KeyType == "" {
+		w.KeyType = "ec256"
+	}
+
+	switch strings.ToLower(w.KeyType) {
+	case "ec256", "ec384", "rsa2048", "rsa4096":
+	default:
+		return errors.New("unsupported key type " + w.KeyType)
+	}
+
+	if w.RenewBefore == 0 {
+		w.RenewBefore = 30 * 24 * time.Hour
+	}
+
+	if w.CheckInterval == 0 {
+		w.CheckInterval = 12 * time.Hour
+	}
+
+	w.requestNotify = make(chan struct{}, 1)
+	w.certificates = map[string]*certificate{}
+	w.httpTokens = map[string]string{}
+	w.tlsCerts = map[string]*tls.Certificate{}
+
+	for _, domain := range w.Domains {
+		names := splitNames(domain)
+		if len(names) == 0 {
+			continue
+		}
+
+		w.certificates[names[0]] = &certificate{names: names}
+	}
+
+	return nil
+}
+
+func (w *Watcher) Watch(certificateChannel chan<- watcher.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("watcher", "acme").Str("directory", w.Directory).Logger()
+	ctx := logger.WithContext(parentCtx)
+	w.certificateChannel = certificateChannel
+
+	if w.StoragePath == "" {
+		logger.Warn().Msg("No storage path configured, account and certificates will not be persisted")
+	}
+
+	if err := w.createClient(ctx); err != nil {
+		return err
+	}
+
+	if err := w.startChallengeServer(ctx, &logger); err != nil {
+		return err
+	}
+
+	w.run(ctx, &logger)
+
+	return nil
+}
+
+// Ready reports whether the configured certificates were loaded or obtained
+// once.
+func (w *Watcher) Ready() error {
+	if atomic.LoadInt32(&w.checked) == 0 {
+		return errors.New("certificates not checked yet")
+	}
+
+	return nil
+}
+
+// DomainsRequested queues the domains requested by a subscriber so a
+// certificate is obtained for every domain not yet covered by this watcher. It
+// never blocks, so a busy or restarting watcher doesn't hold up the caller.
+func (w *Watcher) DomainsRequested(domains []string) {
+	if !w.FromSubscribers {
+		return
+	}
+
+	w.requestLock.Lock()
+	w.requested = append(w.requested, domains...)
+	w.requestLock.Unlock()
+
+	select {
+	case w.requestNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watcher) takeRequested() []string {
+	w.requestLock.Lock()
+	defer w.requestLock.Unlock()
+
+	domains := w.requested
+	w.requested = nil
+
+	return domains
+}
+
+func (w *Watcher) createClient(ctx context.Context) error {
+	accountKey, err := w.loadAccountKey()
+	if err != nil {
+		return err
+	}
+
+	httpClient, err := w.createHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	w.client = &xacme.Client{
+		Key:          accountKey,
+		DirectoryURL: w.Directory,
+		HTTPClient:   httpClient,
+		UserAgent:    "cert-watcher",
+	}
+
+	account := &xacme.Account{}
+	if w.Email != "" {
+		account.Contact = []string{"mailto:" + w.Email}
+	}
+
+	_, err = w.client.Register(ctx, account, xacme.AcceptTOS)
+	if err != nil && !errors.Is(err, xacme.ErrAccountAlreadyExists) {
+		return err
+	}
+
+	return nil
+}
+
+func (w *Watcher) createHTTPClient() (*http.Client, error) {
+	if w.CACertificate == "" {
+		return http.DefaultClient, nil
+	}
+
+	content, err := ioutil.ReadFile(w.CACertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, errors.New("no certificates found in " + w.CACertificate)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func (w *Watcher) run(ctx context.Context, logger *zerolog.Logger) {
+	w.loadCertificates(logger)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Stopping watcher")
+			return
+		case <-w.requestNotify:
+			if w.addDomains(w.takeRequested(), logger) {
+				timer.Reset(0)
+			}
+		case <-timer.C:
+			timer.Reset(w.renewCertificates(ctx, logger))
+			atomic.StoreInt32(&w.checked, 1)
+		}
+	}
+}
+
+func (w *Watcher) addDomains(domains []string, logger *zerolog.Logger) bool {
+	added := false
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || w.covers(domain) {
+			continue
+		}
+
+		logger.Debug().Str("domain", domain).Msg("Adding domain requested by subscriber")
+		certificate := &certificate{names: []string{domain}}
+		w.certificates[domain] = certificate
+		w.loadCertificate(domain, certificate, logger)
+		added = true
+	}
+
+	return added
+}
+
+func (w *Watcher) covers(domain string) bool {
+	for _, certificate := range w.certificates {
+		for _, name := range certificate.names {
+			if name == domain {
+				return true
+			}
+
+			if strings.HasPrefix(name, "*.") {
+				index := strings.Index(domain, ".")
+				if index > 0 && domain[index:] == name[1:] {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// renewCertificates obtains all missing and expiring certificates and returns
+// the duration until the next check should happen. Certificates which failed
+// are only retried once their backoff passed.
+func (w *Watcher) renewCertificates(ctx context.Context, logger *zerolog.Logger) time.Duration {
+	now := time.Now()
+	next := w.CheckInterval
+
+	for main, certificate := range w.certificates {
+		if !certificate.notAfter.IsZero() && time.Until(certificate.notAfter) > w.RenewBefore {
+			continue
+		}
+
+		if now.Before(certificate.retryAt) {
+			next = minDuration(next, certificate.retryAt.Sub(now))
+			continue
+		}
+
+		certificateLogger := logger.With().Str("main_domain", main).Strs("names", certificate.names).Logger()
+		certificateLogger.Info().Msg("Obtaining certificate")
+
+		obtained, notAfter, err := w.obtain(ctx, certificate.names, &certificateLogger)
+		if err != nil {
+			if certificate.backoff == nil {
+				certificate.backoff = newRetryBackOff()
+			}
+
+			delay := certificate.backoff.NextBackOff()
+			certificate.retryAt = now.Add(delay)
+			next = minDuration(next, delay)
+
+			certificateLogger.Error().Err(err).Time("retry_at", certificate.retryAt).Msg("Failed obtaining certificate")
+			continue
+		}
+
+		certificate.notAfter = notAfter
+		certificate.retryAt = time.Time{}
+		certificate.backoff = nil
+
+		if err := w.storeCertificate(main, obtained); err != nil {
+			certificateLogger.Error().Err(err).Msg("Failed storing certificate")
+		}
+
+		certificateLogger.Info().Time("not_after", notAfter).Msg("Obtained certificate")
+		w.emit(obtained)
+	}
+
+	return next
+}
+
+// newRetryBackOff returns the backoff of a failing certificate, it never stops
+// retrying but waits up to a day between attempts.
+func newRetryBackOff() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 5 * time.Minute
+	b.MaxInterval = 24 * time.Hour
+	b.MaxElapsedTime = 0
+	b.Reset()
+
+	return b
+}
+
+func minDuration(a time.Duration, b time.Duration) time.Duration {
+	if b < a {
+		return b
+	}
+
+	return a
+}
+
+func (w *Watcher) obtain(ctx context.Context, names []string, logger *zerolog.Logger) (*cert.Certificate, time.Time, error) {
+	order, err := w.client.AuthorizeOrder(ctx, xacme.DomainIDs(names...))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	for _, url := range order.AuthzURLs {
+		authorization, err := w.client.GetAuthorization(ctx, url)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if authorization.Status == xacme.StatusValid {
+			continue
+		}
+
+		if err := w.authorize(ctx, authorization, logger); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+
+	order, err = w.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	key, err := cert.GenerateKey(w.KeyType)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	csr, err := createCSR(names, key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	chain, _, err := w.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if len(chain) == 0 {
+		return nil, time.Time{}, errors.New("no certificate issued")
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	certificate, err := encodeCertificate(names, chain, key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return certificate, leaf.NotAfter, nil
+}
+
+func (w *Watcher) emit(certificate *cert.Certificate) {
+	w.certificateChannel <- watcher.Message{
+		MonitorName: "acme",
+		Action:      watcher.UpdateCertificate,
+		Certificate: *certificate,
+	}
+}
+
+func splitNames(domain string) []string {
+	var names []string
+	for _, name := range strings.Split(domain, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,267 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/fallback"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"github.com/rs/zerolog"
+	xacme "golang.org/x/crypto/acme"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWatcher(t *testing.T, w *Watcher) chan watcher.Message {
+	t.Helper()
+
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make(chan watcher.Message, 10)
+	w.certificateChannel = messages
+
+	return messages
+}
+
+func TestAddDomainsLoadsStoredCertificate(t *testing.T) {
+	w := &Watcher{StoragePath: t.TempDir(), FromSubscribers: true}
+	messages := newTestWatcher(t, w)
+	logger := zerolog.Nop()
+
+	issuer := &fallback.Issuer{Validity: 90 * 24 * time.Hour}
+	if err := issuer.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := issuer.Issue("a.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.storeCertificate("a.example.com", stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if !w.addDomains([]string{"a.example.com"}, &logger) {
+		t.Fatal("domain wasn't added")
+	}
+
+	if w.certificates["a.example.com"].notAfter.IsZero() {
+		t.Fatal("stored certificate wasn't loaded")
+	}
+
+	select {
+	case message := <-messages:
+		if string(message.Certificate.Cert) != string(stored.Cert) {
+			t.Error("emitted certificate isn't the stored certificate")
+		}
+	default:
+		t.Fatal("stored certificate wasn't emitted")
+	}
+
+	// The client isn't set, so this fails when obtaining a certificate.
+	if next := w.renewCertificates(context.Background(), &logger); next != w.CheckInterval {
+		t.Errorf("next check in %s, expected %s", next, w.CheckInterval)
+	}
+}
+
+func TestRenewCertificatesBacksOff(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(rw, "invalid", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	w := &Watcher{Domains: []string{"a.example.com"}}
+	newTestWatcher(t, w)
+	w.client = &xacme.Client{DirectoryURL: server.URL, HTTPClient: server.Client()}
+	logger := zerolog.Nop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	next := w.renewCertificates(ctx, &logger)
+	certificate := w.certificates["a.example.com"]
+	if certificate.retryAt.IsZero() {
+		t.Fatal("failed certificate not scheduled for retry")
+	}
+
+	if next >= w.CheckInterval || next < time.Minute {
+		t.Errorf("unexpected retry delay %s", next)
+	}
+
+	made := atomic.LoadInt32(&requests)
+	w.renewCertificates(ctx, &logger)
+	if atomic.LoadInt32(&requests) != made {
+		t.Error("certificate retried before its backoff passed")
+	}
+
+	certificate.retryAt = time.Now().Add(-time.Second)
+	w.renewCertificates(ctx, &logger)
+	if atomic.LoadInt32(&requests) == made {
+		t.Error("certificate not retried after its backoff passed")
+	}
+}
+
+func TestStoredCertificateWithMismatchedKey(t *testing.T) {
+	w := &Watcher{StoragePath: t.TempDir(), FromSubscribers: true}
+	messages := newTestWatcher(t, w)
+	logger := zerolog.Nop()
+
+	issuer := &fallback.Issuer{Validity: 90 * 24 * time.Hour}
+	if err := issuer.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := issuer.Issue("a.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := issuer.Issue("a.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored.Key = other.Key
+	if err := w.storeCertificate("a.example.com", stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if !w.addDomains([]string{"a.example.com"}, &logger) {
+		t.Fatal("domain wasn't added")
+	}
+
+	if !w.certificates["a.example.com"].notAfter.IsZero() {
+		t.Error("stored certificate with another key was loaded")
+	}
+
+	select {
+	case <-messages:
+		t.Error("stored certificate with another key was emitted")
+	default:
+	}
+}
+
+func TestSameNames(t *testing.T) {
+	tests := []struct {
+		a    []string
+		b    []string
+		same bool
+	}{
+		{[]string{"a.example.com", "b.example.com"}, []string{"b.example.com", "a.example.com"}, true},
+		{[]string{"A.example.com"}, []string{"a.example.com"}, true},
+		{[]string{"a.example.com"}, []string{"A.EXAMPLE.com"}, true},
+		{[]string{"a.example.com"}, []string{"b.example.com"}, false},
+		{[]string{"a.example.com"}, []string{"a.example.com", "b.example.com"}, false},
+	}
+
+	for _, test := range tests {
+		if same := sameNames(test.a, test.b); same != test.same {
+			t.Errorf("sameNames(%v, %v) = %t, expected %t", test.a, test.b, same, test.same)
+		}
+	}
+}
+
+func TestDNSChallengeCleanedUpWhenCanceled(t *testing.T) {
+	hookLog := filepath.Join(t.TempDir(), "hook.log")
+	w := &Watcher{
+		Domains:             []string{"a.example.com"},
+		Challenge:           challengeDNS01,
+		DNSHook:             []string{"sh", "-c", `echo "$0" >> "` + hookLog + `"`},
+		DNSPropagationDelay: time.Hour,
+	}
+	newTestWatcher(t, w)
+	logger := zerolog.Nop()
+
+	key, err := cert.GenerateKey("ec256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.client = &xacme.Client{Key: key}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	err = w.authorize(ctx, &xacme.Authorization{
+		Identifier: xacme.AuthzID{Type: "dns", Value: "a.example.com"},
+		Challenges: []*xacme.Challenge{{Type: challengeDNS01, Token: "token"}},
+	}, &logger)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the context to be canceled, got %v", err)
+	}
+
+	content, err := ioutil.ReadFile(hookLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "present\ncleanup\n" {
+		t.Errorf("unexpected hook invocations %q", content)
+	}
+}
+
+// TestPebble obtains a certificate from a Pebble test server. It requires
+// PEBBLE_DIRECTORY, e.g. https://localhost:14000/dir, and PEBBLE_CA, the file
+// with the Pebble CA (test/certs/pebble.minica.pem). Pebble has to validate
+// http-01 challenges against PEBBLE_HTTP_ADDRESS (:5002 by default), or run
+// with PEBBLE_VA_ALWAYS_VALID=1.
+func TestPebble(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY")
+	if directory == "" {
+		t.Skip("PEBBLE_DIRECTORY not set")
+	}
+
+	address := os.Getenv("PEBBLE_HTTP_ADDRESS")
+	if address == "" {
+		address = ":5002"
+	}
+
+	w := &Watcher{
+		Directory:     directory,
+		CACertificate: os.Getenv("PEBBLE_CA"),
+		Domains:       []string{"pebble.example.com,www.pebble.example.com"},
+		StoragePath:   t.TempDir(),
+		HTTPAddress:   address,
+	}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	messages := make(chan watcher.Message, 10)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- w.Watch(messages, ctx)
+	}()
+
+	select {
+	case message := <-messages:
+		leaf, err := message.Certificate.Leaf()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !sameNames(leaf.DNSNames, []string{"pebble.example.com", "www.pebble.example.com"}) {
+			t.Errorf("unexpected names %v", leaf.DNSNames)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-ctx.Done():
+		t.Fatal("no certificate obtained")
+	}
+
+	cancel()
+	<-errs
+}
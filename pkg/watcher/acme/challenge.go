@@ -0,0 +1,193 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/rs/zerolog"
+	xacme "golang.org/x/crypto/acme"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+func (w *Watcher) startChallengeServer(ctx context.Context, logger *zerolog.Logger) error {
+	var server *http.Server
+	var listener net.Listener
+	var err error
+
+	switch w.Challenge {
+	case challengeHTTP01:
+		server = &http.Server{Handler: http.HandlerFunc(w.serveHTTP01)}
+		listener, err = net.Listen("tcp", w.HTTPAddress)
+	case challengeTLSALPN01:
+		server = &http.Server{}
+		listener, err = tls.Listen("tcp", w.TLSAddress, &tls.Config{
+			NextProtos:     []string{xacme.ALPNProto},
+			GetCertificate: w.getTLSALPN01Certificate,
+		})
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Str("challenge", w.Challenge).Str("address", listener.Addr().String()).Msg("Listening for ACME challenges")
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msg("Challenge server stopped")
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	return nil
+}
+
+func (w *Watcher) serveHTTP01(rw http.ResponseWriter, req *http.Request) {
+	const prefix = "/.well-known/acme-challenge/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(rw, req)
+		return
+	}
+
+	w.challengeLock.RLock()
+	response, ok := w.httpTokens[strings.TrimPrefix(req.URL.Path, prefix)]
+	w.challengeLock.RUnlock()
+
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.Write([]byte(response))
+}
+
+func (w *Watcher) getTLSALPN01Certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	w.challengeLock.RLock()
+	defer w.challengeLock.RUnlock()
+
+	if certificate, ok := w.tlsCerts[strings.ToLower(hello.ServerName)]; ok {
+		return certificate, nil
+	}
+
+	return nil, errors.New("no challenge certificate for " + hello.ServerName)
+}
+
+func (w *Watcher) authorize(ctx context.Context, authorization *xacme.Authorization, logger *zerolog.Logger) error {
+	domain := authorization.Identifier.Value
+	challengeLogger := logger.With().Str("authorization_domain", domain).Str("challenge", w.Challenge).Logger()
+
+	var challenge *xacme.Challenge
+	for _, c := range authorization.Challenges {
+		if c.Type == w.Challenge {
+			challenge = c
+			break
+		}
+	}
+
+	if challenge == nil {
+		return errors.New("challenge " + w.Challenge + " not offered for " + domain)
+	}
+
+	cleanup, err := w.present(ctx, domain, challenge)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := cleanup(); err != nil {
+			challengeLogger.Error().Err(err).Msg("Failed cleaning up challenge")
+		}
+	}()
+
+	// The propagation is awaited after deferring the cleanup, so the record
+	// is removed as well when the context is done while waiting.
+	if w.Challenge == challengeDNS01 && w.DNSPropagationDelay > 0 {
+		challengeLogger.Debug().Dur("delay", w.DNSPropagationDelay).Msg("Waiting for DNS propagation")
+		select {
+		case <-time.After(w.DNSPropagationDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	challengeLogger.Debug().Msg("Accepting challenge")
+	if _, err := w.client.Accept(ctx, challenge); err != nil {
+		return err
+	}
+
+	_, err = w.client.WaitAuthorization(ctx, authorization.URI)
+	return err
+}
+
+func (w *Watcher) present(ctx context.Context, domain string, challenge *xacme.Challenge) (func() error, error) {
+	switch w.Challenge {
+	case challengeHTTP01:
+		response, err := w.client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		w.challengeLock.Lock()
+		w.httpTokens[challenge.Token] = response
+		w.challengeLock.Unlock()
+
+		return func() error {
+			w.challengeLock.Lock()
+			delete(w.httpTokens, challenge.Token)
+			w.challengeLock.Unlock()
+			return nil
+		}, nil
+	case challengeTLSALPN01:
+		certificate, err := w.client.TLSALPN01ChallengeCert(challenge.Token, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		w.challengeLock.Lock()
+		w.tlsCerts[domain] = &certificate
+		w.challengeLock.Unlock()
+
+		return func() error {
+			w.challengeLock.Lock()
+			delete(w.tlsCerts, domain)
+			w.challengeLock.Unlock()
+			return nil
+		}, nil
+	case challengeDNS01:
+		value, err := w.client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		fqdn := "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+		if err := w.runDNSHook(ctx, "present", fqdn, value); err != nil {
+			return nil, err
+		}
+
+		return func() error {
+			return w.runDNSHook(context.Background(), "cleanup", fqdn, value)
+		}, nil
+	default:
+		return nil, errors.New("unsupported challenge type " + w.Challenge)
+	}
+}
+
+func (w *Watcher) runDNSHook(ctx context.Context, action string, fqdn string, value string) error {
+	args := append(append([]string{}, w.DNSHook[1:]...), action, fqdn, value)
+	output, err := exec.CommandContext(ctx, w.DNSHook[0], args...).CombinedOutput()
+	if err != nil {
+		return errors.New("dns hook failed: " + err.Error() + ": " + strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
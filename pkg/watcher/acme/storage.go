@@ -0,0 +1,219 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (w *Watcher) loadAccountKey() (crypto.Signer, error) {
+	if w.StoragePath == "" {
+		return cert.GenerateKey("ec256")
+	}
+
+	path := filepath.Join(w.StoragePath, "account.key")
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		return cert.DecodeKey(content)
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := cert.GenerateKey("ec256")
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := cert.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(w.StoragePath, 0700); err != nil {
+		return nil, err
+	}
+
+	return key, ioutil.WriteFile(path, encoded, 0600)
+}
+
+func (w *Watcher) loadCertificates(logger *zerolog.Logger) {
+	for main, certificate := range w.certificates {
+		w.loadCertificate(main, certificate, logger)
+	}
+}
+
+// loadCertificate emits the stored certificate, if it still covers the names
+// of the certificate, so it isn't obtained again.
+func (w *Watcher) loadCertificate(main string, certificate *certificate, parentLogger *zerolog.Logger) {
+	if w.StoragePath == "" {
+		return
+	}
+
+	logger := parentLogger.With().Str("main_domain", main).Logger()
+
+	crt, err := ioutil.ReadFile(w.certificatePath(main, "crt"))
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		logger.Error().Err(err).Msg("Error reading stored certificate")
+		return
+	}
+
+	key, err := ioutil.ReadFile(w.certificatePath(main, "key"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Error reading stored key")
+		return
+	}
+
+	pair, err := tls.X509KeyPair(crt, key)
+	if err != nil {
+		logger.Error().Err(err).Msg("Stored certificate and key don't form a valid pair")
+		return
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		logger.Error().Err(err).Msg("Error parsing stored certificate")
+		return
+	}
+
+	if !sameNames(leaf.DNSNames, certificate.names) {
+		logger.Info().Strs("stored_names", leaf.DNSNames).Msg("Stored certificate names differ, obtaining new certificate")
+		return
+	}
+
+	logger.Debug().Time("not_after", leaf.NotAfter).Msg("Loaded stored certificate")
+	certificate.notAfter = leaf.NotAfter
+	w.emit(&cert.Certificate{
+		Names: certificate.names,
+		Cert:  crt,
+		Key:   key,
+	})
+}
+
+func (w *Watcher) storeCertificate(main string, certificate *cert.Certificate) error {
+	if w.StoragePath == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(w.StoragePath, "certificates"), 0700); err != nil {
+		return err
+	}
+
+	// Both files are written before either is replaced, so a failed write
+	// doesn't leave a key next to a certificate it doesn't belong to. Each
+	// file is replaced atomically, the pair isn't.
+	files := []struct {
+		path    string
+		content []byte
+	}{
+		{w.certificatePath(main, "key"), certificate.Key},
+		{w.certificatePath(main, "crt"), certificate.Cert},
+	}
+
+	var temporary []string
+	defer func() {
+		for _, path := range temporary {
+			os.Remove(path)
+		}
+	}()
+
+	for _, file := range files {
+		path, err := writeTemporaryFile(file.path, file.content)
+		if err != nil {
+			return err
+		}
+		temporary = append(temporary, path)
+	}
+
+	for i, file := range files {
+		if err := os.Rename(temporary[i], file.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeTemporaryFile writes the content to a temporary file, readable by the
+// owner only, next to the path.
+func writeTemporaryFile(path string, content []byte) (string, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+func (w *Watcher) certificatePath(main string, extension string) string {
+	fileName := strings.Replace(main, "*", "_", -1) + "." + extension
+	return filepath.Join(w.StoragePath, "certificates", fileName)
+}
+
+func sameNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	names := map[string]bool{}
+	for _, name := range a {
+		names[strings.ToLower(name)] = true
+	}
+
+	for _, name := range b {
+		if !names[strings.ToLower(name)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func createCSR(names []string, key crypto.Signer) ([]byte, error) {
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}
+
+	return x509.CreateCertificateRequest(rand.Reader, template, key)
+}
+
+func encodeCertificate(names []string, chain [][]byte, key crypto.Signer) (*cert.Certificate, error) {
+	var crt []byte
+	for _, der := range chain {
+		crt = append(crt, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	encodedKey, err := cert.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert.Certificate{
+		Names: names,
+		Cert:  crt,
+		Key:   encodedKey,
+	}, nil
+}
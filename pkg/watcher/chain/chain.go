@@ -2,38 +2,71 @@ package chain
 
 import (
 	"context"
+	"fmt"
 	"github.com/RobertMe/cert-watcher/pkg/config/static"
+	"github.com/RobertMe/cert-watcher/pkg/health"
+	"github.com/RobertMe/cert-watcher/pkg/supervisor"
 	"github.com/RobertMe/cert-watcher/pkg/watcher"
 	"github.com/rs/zerolog/log"
-	"reflect"
+	"golang.org/x/sync/errgroup"
 )
 
 type WatcherChain struct {
 	Watchers []watcher.Watcher
+
+	names      []string
+	supervisor *supervisor.Supervisor
 }
 
 func NewWatcherChain(conf static.Watchers) *WatcherChain {
-	w := WatcherChain{}
+	w := WatcherChain{
+		supervisor: supervisor.New("watcher"),
+	}
 
-	if conf.Traefik != nil {
-		w.quietAddWatcher(conf.Traefik)
+	for _, instance := range conf {
+		w.quietAddWatcher(instance)
 	}
 
 	return &w
 }
 
-func (w *WatcherChain) quietAddWatcher(watcher watcher.Watcher) {
-	if err := w.AddWatcher(watcher); err != nil {
-		log.Error().Err(err).Str("watcher", reflect.TypeOf(watcher).String()).Msg("Failed initializing watcher")
+func (w *WatcherChain) quietAddWatcher(instance static.Instance) {
+	if err := w.AddInstance(instance); err != nil {
+		log.Error().Err(err).Str("watcher", instance.Name).Str("type", instance.Type).Msg("Failed initializing watcher")
+	}
+}
+
+// AddInstance creates a watcher of the registered type of the instance and
+// adds it to the chain.
+func (w *WatcherChain) AddInstance(instance static.Instance) error {
+	registration, ok := watcher.Lookup(instance.Type)
+	if !ok {
+		return fmt.Errorf("unknown watcher type %s", instance.Type)
+	}
+
+	watch, err := registration.New(instance.Config)
+	if err != nil {
+		return err
 	}
+
+	return w.AddWatcher(instance.Name, watch)
 }
 
-func (w *WatcherChain) AddWatcher(watcher watcher.Watcher) error {
+// AddWatcher initializes the watcher and adds it to the chain. The name
+// identifies the watcher and is used as the monitor name of its messages.
+func (w *WatcherChain) AddWatcher(name string, watcher watcher.Watcher) error {
+	for _, existing := range w.names {
+		if existing == name {
+			return fmt.Errorf("watcher %s already exists", name)
+		}
+	}
+
 	if err := watcher.Init(); err != nil {
 		return err
 	}
 
 	w.Watchers = append(w.Watchers, watcher)
+	w.names = append(w.names, name)
 
 	return nil
 }
@@ -43,13 +76,74 @@ func (w *WatcherChain) Init() error {
 }
 
 func (w *WatcherChain) Watch(certificateChannel chan<- watcher.Message, parentCtx context.Context) error {
-	logger := log.Ctx(parentCtx).With().Str("watcher", "chain").Logger()
+	group := errgroup.Group{}
+	for i, name := range w.names {
+		watch, name := w.Watchers[i], name
+		group.Go(func() error {
+			messages := make(chan watcher.Message)
+			forwarded := make(chan struct{})
+			go func() {
+				defer close(forwarded)
+				forward(messages, certificateChannel, name)
+			}()
+
+			logger := log.Ctx(parentCtx).With().Str("instance", name).Logger()
+			ctx := logger.WithContext(parentCtx)
+			w.supervisor.Run(ctx, name, func(ctx context.Context) error {
+				return watch.Watch(messages, ctx)
+			})
+
+			close(messages)
+			<-forwarded
+
+			return nil
+		})
+	}
+
+	return group.Wait()
+}
+
+// forward sends the messages of a watcher on with the name of its instance.
+func forward(messages <-chan watcher.Message, certificateChannel chan<- watcher.Message, name string) {
+	for message := range messages {
+		message.MonitorName = name
+		certificateChannel <- message
+	}
+}
+
+// Statuses returns the status of the supervised watchers.
+func (w *WatcherChain) Statuses() []supervisor.Status {
+	return w.supervisor.Statuses()
+}
+
+func (w *WatcherChain) DomainsRequested(domains []string) {
 	for _, watch := range w.Watchers {
-		go func(watch watcher.Watcher) {
-			if err := watch.Watch(certificateChannel, parentCtx); err != nil {
-				logger.Error().Err(err).Str("failed_watcher", reflect.TypeOf(watch).String()).Msg("Failed starting watcher")
-			}
-		}(watch)
+		if listener, ok := watch.(watcher.DomainListener); ok {
+			listener.DomainsRequested(domains)
+		}
 	}
-	return nil
+}
+
+// Ready reports the watchers which aren't running or not ready yet.
+func (w *WatcherChain) Ready() error {
+	errs := []error{w.supervisor.Ready()}
+	for i, name := range w.names {
+		if err := health.Ready(w.Watchers[i]); err != nil {
+			errs = append(errs, fmt.Errorf("watcher/%s: %w", name, err))
+		}
+	}
+
+	return health.Combine(errs...)
+}
+
+// Healthy reports the watchers which failed or aren't healthy.
+func (w *WatcherChain) Healthy() error {
+	errs := []error{w.supervisor.Healthy()}
+	for i, name := range w.names {
+		if err := health.Healthy(w.Watchers[i]); err != nil {
+			errs = append(errs, fmt.Errorf("watcher/%s: %w", name, err))
+		}
+	}
+
+	return health.Combine(errs...)
 }
@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/plugin"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"github.com/rs/zerolog/log"
+	"sync/atomic"
+)
+
+// Watcher runs an external program sending certificates, see package plugin
+// for the protocol.
+type Watcher struct {
+	plugin.Command `yaml:",inline"`
+
+	ready int32
+}
+
+func init() {
+	watcher.Register(watcher.Registration{
+		Type:   "plugin",
+		Config: func() interface{} { return &Watcher{} },
+		New: func(config interface{}) (watcher.Watcher, error) {
+			return config.(*Watcher), nil
+		},
+	})
+}
+
+func (w *Watcher) Watch(certificateChannel chan<- watcher.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("watcher", "plugin").Logger()
+	ctx := logger.WithContext(parentCtx)
+
+	atomic.StoreInt32(&w.ready, 0)
+
+	process, err := w.Start(ctx, plugin.KindWatcher)
+	if err != nil {
+		return err
+	}
+	defer process.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Stopping watcher")
+			return nil
+		case message, ok := <-process.Messages():
+			if !ok {
+				return process.Err()
+			}
+
+			switch message.Type {
+			case plugin.TypeReady:
+				atomic.StoreInt32(&w.ready, 1)
+			case plugin.TypeCertificate:
+				if message.Action != watcher.UpdateCertificate && message.Action != watcher.RemoveCertificate {
+					logger.Error().Str("action", message.Action).Msg("Unknown certificate action from plugin")
+					continue
+				}
+
+				select {
+				case certificateChannel <- watcher.Message{
+					MonitorName: "plugin",
+					Action:      message.Action,
+					Certificate: cert.Certificate{
+						Names: message.Names,
+						Cert:  []byte(message.Cert),
+						Key:   []byte(message.Key),
+					},
+				}:
+				case <-ctx.Done():
+					logger.Info().Msg("Stopping watcher")
+					return nil
+				}
+			default:
+				logger.Error().Str("type", message.Type).Msg("Unexpected message from plugin")
+			}
+		}
+	}
+}
+
+// Ready reports whether the plugin is set up.
+func (w *Watcher) Ready() error {
+	if atomic.LoadInt32(&w.ready) == 0 {
+		return errors.New("plugin isn't ready")
+	}
+
+	return nil
+}
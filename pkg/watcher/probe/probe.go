@@ -0,0 +1,167 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+type Endpoint struct {
+	Address    string `description:"Address (host:port) to connect to" json:"address" yaml:"address"`
+	ServerName string `description:"Server name to send using SNI, defaults to the host of the address" json:"server_name" yaml:"server_name"`
+}
+
+type Watcher struct {
+	Endpoints []Endpoint    `description:"Endpoints to probe" json:"endpoints" yaml:"endpoints"`
+	Interval  time.Duration `description:"Interval between probes" json:"interval" yaml:"interval"`
+	Timeout   time.Duration `description:"Timeout of a single probe" json:"timeout" yaml:"timeout"`
+
+	certificateChannel chan<- watcher.Message
+	probed             int32
+}
+
+func init() {
+	watcher.Register(watcher.Registration{
+		Type:   "probe",
+		Config: func() interface{} { return &Watcher{} },
+		New: func(config interface{}) (watcher.Watcher, error) {
+			return config.(*Watcher), nil
+		},
+	})
+}
+
+func (w *Watcher) Init() error {
+	if len(w.Endpoints) == 0 {
+		return errors.New("no endpoints configured")
+	}
+
+	for i, endpoint := range w.Endpoints {
+		host, _, err := net.SplitHostPort(endpoint.Address)
+		if err != nil {
+			return err
+		}
+
+		if endpoint.ServerName == "" {
+			w.Endpoints[i].ServerName = host
+		}
+	}
+
+	if w.Interval == 0 {
+		w.Interval = 5 * time.Minute
+	}
+
+	if w.Timeout == 0 {
+		w.Timeout = 10 * time.Second
+	}
+
+	return nil
+}
+
+func (w *Watcher) Watch(certificateChannel chan<- watcher.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("watcher", "probe").Logger()
+	w.certificateChannel = certificateChannel
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if !w.probeAll(&logger, parentCtx) {
+			logger.Info().Msg("Stopping watcher")
+			return nil
+		}
+		atomic.StoreInt32(&w.probed, 1)
+
+		select {
+		case <-parentCtx.Done():
+			logger.Info().Msg("Stopping watcher")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ready reports whether every endpoint was probed once.
+func (w *Watcher) Ready() error {
+	if atomic.LoadInt32(&w.probed) == 0 {
+		return errors.New("endpoints not probed yet")
+	}
+
+	return nil
+}
+
+// probeAll probes every endpoint, it returns false when the context is done
+// before all endpoints were probed.
+func (w *Watcher) probeAll(parentLogger *zerolog.Logger, ctx context.Context) bool {
+	for _, endpoint := range w.Endpoints {
+		logger := parentLogger.With().
+			Str("endpoint", endpoint.Address).
+			Str("server_name", endpoint.ServerName).
+			Logger()
+
+		certificate, err := w.probe(endpoint, ctx)
+		if ctx.Err() != nil {
+			return false
+		} else if err != nil {
+			logger.Error().Err(err).Msg("Failed probing endpoint")
+			continue
+		}
+
+		logger.Debug().Msg("Probed endpoint")
+
+		select {
+		case w.certificateChannel <- watcher.Message{
+			MonitorName: "probe",
+			Action:      watcher.ServedCertificate,
+			Endpoint:    endpoint.Address,
+			Certificate: *certificate,
+		}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *Watcher) probe(endpoint Endpoint, parentCtx context.Context) (*cert.Certificate, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, w.Timeout)
+	defer cancel()
+
+	dialer := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName: endpoint.ServerName,
+			// The served certificate is recorded as is, verifying it would
+			// make it impossible to detect expired or otherwise invalid
+			// certificates.
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	peerCertificates := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(peerCertificates) == 0 {
+		return nil, errors.New("no certificate served")
+	}
+
+	var encoded []byte
+	for _, peerCertificate := range peerCertificates {
+		encoded = append(encoded, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: peerCertificate.Raw})...)
+	}
+
+	return &cert.Certificate{
+		Names: []string{endpoint.ServerName},
+		Cert:  encoded,
+	}, nil
+}
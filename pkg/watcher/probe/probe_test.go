@@ -0,0 +1,64 @@
+package probe
+
+import (
+	"context"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"github.com/rs/zerolog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeAll(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	// A closed listener leaves an address nothing listens on.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	messages := make(chan watcher.Message, 10)
+	w := &Watcher{Endpoints: []Endpoint{
+		{Address: closed, ServerName: "example.com"},
+		{Address: server.Listener.Addr().String(), ServerName: "example.com"},
+	}}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	w.certificateChannel = messages
+	logger := zerolog.Nop()
+
+	if !w.probeAll(&logger, context.Background()) {
+		t.Fatal("probing stopped")
+	}
+	close(messages)
+
+	var received []watcher.Message
+	for message := range messages {
+		received = append(received, message)
+	}
+
+	// The failed probe doesn't report anything, in particular not a removal.
+	if len(received) != 1 {
+		t.Fatalf("received %d messages, expected 1", len(received))
+	}
+
+	message := received[0]
+	if message.Action != watcher.ServedCertificate || message.Endpoint != server.Listener.Addr().String() {
+		t.Errorf("unexpected message %s for %s", message.Action, message.Endpoint)
+	}
+
+	leaf, err := message.Certificate.Leaf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !leaf.Equal(server.Certificate()) {
+		t.Error("reported certificate isn't the served certificate")
+	}
+}
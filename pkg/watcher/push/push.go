@@ -0,0 +1,286 @@
+package push
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const maxBodySize = 1 << 20
+
+type Watcher struct {
+	Address        string `description:"Address to listen on" json:"address" yaml:"address"`
+	Path           string `description:"Path certificates are posted to" json:"path" yaml:"path"`
+	Token          string `description:"Bearer token clients have to authenticate with" json:"-" yaml:"token"`
+	TLSCertificate string `description:"PEM file with the certificate to serve TLS with" json:"tls_certificate" yaml:"tls_certificate"`
+	TLSKey         string `description:"PEM file with the key to serve TLS with" json:"tls_key" yaml:"tls_key"`
+	ClientCA       string `description:"PEM file with the CA client certificates have to be signed by" json:"client_ca" yaml:"client_ca"`
+
+	certificateChannel chan<- watcher.Message
+	tlsConfig          *tls.Config
+}
+
+type payload struct {
+	Names []string `json:"names"`
+	Cert  string   `json:"cert"`
+	Key   string   `json:"key"`
+}
+
+func init() {
+	watcher.Register(watcher.Registration{
+		Type:   "push",
+		Config: func() interface{} { return &Watcher{} },
+		New: func(config interface{}) (watcher.Watcher, error) {
+			return config.(*Watcher), nil
+		},
+	})
+}
+
+func (w *Watcher) Init() error {
+	if w.Address == "" {
+		w.Address = ":8443"
+	}
+
+	if w.Path == "" {
+		w.Path = "/certificates"
+	}
+
+	if w.Token == "" && w.ClientCA == "" {
+		return errors.New("either a token or a client_ca is required to authenticate clients")
+	}
+
+	if w.TLSCertificate == "" && w.TLSKey == "" {
+		if w.ClientCA != "" {
+			return errors.New("client_ca requires tls_certificate and tls_key")
+		}
+
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(w.TLSCertificate, w.TLSKey)
+	if err != nil {
+		return err
+	}
+
+	w.tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	if w.ClientCA != "" {
+		content, err := ioutil.ReadFile(w.ClientCA)
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return errors.New("no certificates found in " + w.ClientCA)
+		}
+
+		w.tlsConfig.ClientCAs = pool
+		w.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return nil
+}
+
+func (w *Watcher) Watch(certificateChannel chan<- watcher.Message, parentCtx context.Context) error {
+	logger := log.Ctx(parentCtx).With().Str("watcher", "push").Logger()
+	w.certificateChannel = certificateChannel
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(w.Path, func(rw http.ResponseWriter, req *http.Request) {
+		w.handle(rw, req, &logger, parentCtx)
+	})
+
+	listener, err := net.Listen("tcp", w.Address)
+	if err != nil {
+		return err
+	}
+
+	if w.tlsConfig != nil {
+		listener = tls.NewListener(listener, w.tlsConfig)
+	} else {
+		logger.Warn().Msg("Serving without TLS, tokens and keys are sent in plain text")
+	}
+
+	server := &http.Server{Handler: mux}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	go func() {
+		select {
+		case <-parentCtx.Done():
+			logger.Info().Msg("Stopping watcher")
+			server.Close()
+		case <-stopped:
+		}
+	}()
+
+	logger.Info().Str("address", listener.Addr().String()).Str("path", w.Path).Msg("Listening for pushed certificates")
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func (w *Watcher) handle(rw http.ResponseWriter, req *http.Request, parentLogger *zerolog.Logger, ctx context.Context) {
+	logger := parentLogger.With().Str("remote_address", req.RemoteAddr).Logger()
+
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !w.authenticated(req) {
+		logger.Warn().Msg("Rejected unauthenticated certificate push")
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, maxBodySize))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var certificate *cert.Certificate
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		certificate, err = parseJSON(body)
+	} else {
+		certificate, err = parsePEM(body)
+	}
+
+	if err == nil {
+		err = validate(certificate)
+	}
+
+	if err != nil {
+		logger.Error().Err(err).Msg("Rejected invalid certificate push")
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Info().Strs("names", certificate.Names).Msg("Received pushed certificate")
+
+	select {
+	case w.certificateChannel <- watcher.Message{
+		MonitorName: "push",
+		Action:      watcher.UpdateCertificate,
+		Certificate: *certificate,
+	}:
+	case <-ctx.Done():
+		http.Error(rw, "stopping", http.StatusServiceUnavailable)
+		return
+	case <-req.Context().Done():
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+func (w *Watcher) authenticated(req *http.Request) bool {
+	if w.ClientCA != "" && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+		return false
+	}
+
+	if w.Token != "" {
+		const prefix = "Bearer "
+		authorization := req.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, prefix) {
+			return false
+		}
+
+		token := authorization[len(prefix):]
+		return subtle.ConstantTimeCompare([]byte(token), []byte(w.Token)) == 1
+	}
+
+	return true
+}
+
+func parseJSON(body []byte) (*cert.Certificate, error) {
+	var p payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+
+	return &cert.Certificate{
+		Names: p.Names,
+		Cert:  []byte(p.Cert),
+		Key:   []byte(p.Key),
+	}, nil
+}
+
+func parsePEM(body []byte) (*cert.Certificate, error) {
+	certificate := &cert.Certificate{}
+	for {
+		var block *pem.Block
+		block, body = pem.Decode(body)
+		if block == nil {
+			break
+		}
+
+		if block.Type == "CERTIFICATE" {
+			certificate.Cert = append(certificate.Cert, pem.EncodeToMemory(block)...)
+		} else if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			if certificate.Key != nil {
+				return nil, errors.New("bundle contains multiple keys")
+			}
+
+			certificate.Key = pem.EncodeToMemory(block)
+		}
+	}
+
+	return certificate, nil
+}
+
+func validate(certificate *cert.Certificate) error {
+	if len(certificate.Cert) == 0 || len(certificate.Key) == 0 {
+		return errors.New("certificate and key are required")
+	}
+
+	if _, err := tls.X509KeyPair(certificate.Cert, certificate.Key); err != nil {
+		return err
+	}
+
+	leaf, err := certificate.Leaf()
+	if err != nil {
+		return err
+	}
+
+	if len(certificate.Names) > 0 {
+		// Names sent along with the certificate have to be covered by it.
+		for _, name := range certificate.Names {
+			if err := leaf.VerifyHostname(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	certificate.Names = leaf.DNSNames
+	if len(certificate.Names) == 0 && leaf.Subject.CommonName != "" {
+		certificate.Names = []string{leaf.Subject.CommonName}
+	}
+
+	if len(certificate.Names) == 0 {
+		return errors.New("certificate does not contain any names")
+	}
+
+	return nil
+}
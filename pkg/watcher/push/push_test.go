@@ -0,0 +1,147 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"github.com/rs/zerolog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestBundle returns a self-signed PEM certificate and its key.
+func newTestBundle(t *testing.T, commonName string, names ...string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := cert.GenerateKey("ec256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPem, err := cert.EncodeKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPem
+}
+
+func TestAuthenticated(t *testing.T) {
+	crt, key := newTestBundle(t, "a.example.com", "a.example.com")
+	body := append(append([]byte{}, crt...), key...)
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}
+
+	tests := []struct {
+		name          string
+		token         string
+		clientCA      string
+		authorization string
+		tls           *tls.ConnectionState
+		status        int
+	}{
+		{name: "valid token", token: "secret", authorization: "Bearer secret", status: http.StatusAccepted},
+		{name: "missing token", token: "secret", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer other", status: http.StatusUnauthorized},
+		{name: "bearer without token", token: "secret", authorization: "Bearer ", status: http.StatusUnauthorized},
+		{name: "token without bearer", token: "secret", authorization: "secret", status: http.StatusUnauthorized},
+		{name: "client certificate", clientCA: "ca.pem", tls: verified, status: http.StatusAccepted},
+		{name: "client CA without TLS", clientCA: "ca.pem", status: http.StatusUnauthorized},
+		{name: "client CA without verified chain", clientCA: "ca.pem", tls: &tls.ConnectionState{}, status: http.StatusUnauthorized},
+		{name: "client certificate and token", token: "secret", clientCA: "ca.pem", tls: verified, status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages := make(chan watcher.Message, 1)
+			w := &Watcher{Token: test.token, ClientCA: test.clientCA, certificateChannel: messages}
+			logger := zerolog.Nop()
+
+			req := httptest.NewRequest(http.MethodPost, "/certificates", bytes.NewReader(body))
+			req.TLS = test.tls
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+
+			rw := httptest.NewRecorder()
+			w.handle(rw, req, &logger, context.Background())
+
+			if rw.Code != test.status {
+				t.Errorf("status %d, expected %d", rw.Code, test.status)
+			}
+
+			if pushed := len(messages) > 0; pushed != (test.status == http.StatusAccepted) {
+				t.Errorf("certificate pushed %t", pushed)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	crt, key := newTestBundle(t, "a.example.com", "a.example.com", "*.b.example.com")
+	_, otherKey := newTestBundle(t, "a.example.com", "a.example.com")
+	commonNameCrt, commonNameKey := newTestBundle(t, "c.example.com")
+
+	tests := []struct {
+		name   string
+		cert   []byte
+		key    []byte
+		names  []string
+		valid  bool
+		result []string
+	}{
+		{name: "names of the certificate", cert: crt, key: key, valid: true, result: []string{"a.example.com", "*.b.example.com"}},
+		{name: "covered names", cert: crt, key: key, names: []string{"a.example.com", "c.b.example.com"}, valid: true, result: []string{"a.example.com", "c.b.example.com"}},
+		{name: "uncovered name", cert: crt, key: key, names: []string{"a.example.com", "c.example.com"}},
+		{name: "mismatched key", cert: crt, key: otherKey},
+		{name: "missing key", cert: crt},
+		{name: "common name fallback", cert: commonNameCrt, key: commonNameKey, valid: true, result: []string{"c.example.com"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certificate := &cert.Certificate{Names: test.names, Cert: test.cert, Key: test.key}
+			err := validate(certificate)
+			if (err == nil) != test.valid {
+				t.Fatalf("valid %t, expected %t: %v", err == nil, test.valid, err)
+			}
+
+			if test.valid && !reflect.DeepEqual(certificate.Names, test.result) {
+				t.Errorf("names %v, expected %v", certificate.Names, test.result)
+			}
+		})
+	}
+}
+
+func TestParsePEMMultipleKeys(t *testing.T) {
+	crt, key := newTestBundle(t, "a.example.com", "a.example.com")
+	_, otherKey := newTestBundle(t, "a.example.com", "a.example.com")
+
+	bundle := append(append(append([]byte{}, crt...), key...), otherKey...)
+	if _, err := parsePEM(bundle); err == nil {
+		t.Error("bundle with multiple keys accepted")
+	}
+}
@@ -0,0 +1,45 @@
+package traefik
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Ready reports whether acme.json was read successfully at least once.
+func (w *Watcher) Ready() error {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
+
+	if w.read {
+		return nil
+	}
+
+	if w.readErr != nil {
+		return fmt.Errorf("acme.json not read yet: %w", w.readErr)
+	}
+
+	return errors.New("acme.json not read yet")
+}
+
+// Healthy reports whether the last read acme.json could be parsed.
+func (w *Watcher) Healthy() error {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
+
+	if w.parseErr != nil {
+		return fmt.Errorf("parsing acme.json: %w", w.parseErr)
+	}
+
+	return nil
+}
+
+func (w *Watcher) recordRead(readErr error, parseErr error) {
+	w.stateLock.Lock()
+	defer w.stateLock.Unlock()
+
+	w.readErr = readErr
+	w.parseErr = parseErr
+	if readErr == nil && parseErr == nil {
+		w.read = true
+	}
+}
@@ -2,27 +2,66 @@ package traefik
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"github.com/RobertMe/cert-watcher/pkg/cert"
 	"github.com/RobertMe/cert-watcher/pkg/watcher"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/fsnotify/fsnotify.v1"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Watcher struct {
-	AcmePath string `description:"Path to the acme.json file" json:"acme_path" yaml:"acme_path"`
+	AcmePath        string        `description:"Path to the acme.json file" json:"acme_path" yaml:"acme_path"`
+	PollInterval    time.Duration `description:"Interval to poll acme.json for changes, disabled when zero" json:"poll_interval" yaml:"poll_interval"`
+	DisableFsnotify bool          `description:"Only poll acme.json, for filesystems not delivering change events" json:"disable_fsnotify" yaml:"disable_fsnotify"`
 
 	certificateChannel chan<- watcher.Message
 	watcher *fsnotify.Watcher
 	watching string
+
+	readLock sync.Mutex
+	readSum  [sha256.Size]byte
+	sum      [sha256.Size]byte
+	previous map[string]providedCertificate
+
+	stateLock sync.Mutex
+	read      bool
+	readErr   error
+	parseErr  error
+}
+
+// providedCertificate is a certificate read from acme.json and the resolver
+// which provided it.
+type providedCertificate struct {
+	provider    string
+	certificate cert.Certificate
+}
+
+func init() {
+	watcher.Register(watcher.Registration{
+		Type:   "traefik",
+		Config: func() interface{} { return &Watcher{} },
+		New: func(config interface{}) (watcher.Watcher, error) {
+			return config.(*Watcher), nil
+		},
+	})
 }
 
 func (w *Watcher) Init() error {
+	if w.DisableFsnotify && w.PollInterval <= 0 {
+		return errors.New("disabling fsnotify requires a poll_interval")
+	}
+
 	return nil
 }
 
@@ -31,49 +70,119 @@ func (w *Watcher) Watch(certificateChannel chan<- watcher.Message, parentCtx con
 	ctxLog := logger.WithContext(parentCtx)
 	w.certificateChannel = certificateChannel
 
-	var err error
-	w.watcher, err = fsnotify.NewWatcher()
-	if err != nil {
-		return err
+	if !w.DisableFsnotify {
+		var err error
+		w.watcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		defer w.watcher.Close()
+
+		// The path watched by a previous run isn't added to this watcher.
+		w.watching = ""
+	}
+
+	group, ctx := errgroup.WithContext(ctxLog)
+
+	if w.PollInterval > 0 {
+		logger.Info().Dur("poll_interval", w.PollInterval).Msg("Polling acme.json for changes")
+		group.Go(func() error {
+			w.poll(ctx, &logger)
+			return nil
+		})
 	}
 
-	go func() {
-		ctx, cancel := context.WithCancel(ctxLog)
-		defer cancel()
-
-		for {
-			select {
-			case event := <-w.watcher.Events:
-				if event.Op&fsnotify.Remove == fsnotify.Remove || event.Op&fsnotify.Rename == fsnotify.Rename {
-					w.updateWatch(filepath.Dir(event.Name), &logger)
-				} else {
-					w.updateWatch(w.AcmePath, &logger)
-				}
-				if event.Name == w.AcmePath &&
-					(event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Write == fsnotify.Write) {
-					w.readFile(&logger)
-				}
-				case err := <-w.watcher.Errors:
-					logger.Error().Err(err).Msg("Error watching for acme.json changes")
-				case <-ctx.Done():
-					return
+	if w.watcher != nil {
+		w.updateWatch(w.AcmePath, &logger)
+		group.Go(func() error {
+			return w.watchEvents(ctx, &logger)
+		})
+	}
+
+	w.readFile(&logger)
+
+	<-ctx.Done()
+	logger.Info().Msg("Stopping watcher")
+
+	return group.Wait()
+}
+
+func (w *Watcher) watchEvents(ctx context.Context, logger *zerolog.Logger) error {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return errors.New("fsnotify watcher closed")
+			}
+			if event.Op&fsnotify.Remove == fsnotify.Remove || event.Op&fsnotify.Rename == fsnotify.Rename {
+				w.updateWatch(filepath.Dir(event.Name), logger)
+			} else {
+				w.updateWatch(w.AcmePath, logger)
 			}
+			if event.Name == w.AcmePath &&
+				(event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Write == fsnotify.Write) {
+				w.readFile(logger)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return errors.New("fsnotify watcher closed")
+			}
+			logger.Error().Err(err).Msg("Error watching for acme.json changes")
+		case <-ctx.Done():
+			return nil
 		}
-	}()
+	}
+}
 
-	w.updateWatch(w.AcmePath, &logger)
+func (w *Watcher) poll(ctx context.Context, logger *zerolog.Logger) {
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
 
-	go w.readFile(&logger)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 
-	return nil
+		// The contents are hashed as the modification time and size can stay
+		// the same, e.g. on filesystems with a coarse timestamp granularity.
+		content, err := ioutil.ReadFile(w.AcmePath)
+		if err != nil {
+			logger.Debug().Err(err).Msg("Unable to read acme.json file while polling")
+			continue
+		}
+
+		w.readLock.Lock()
+		changed := sha256.Sum256(content) != w.readSum
+		w.readLock.Unlock()
+
+		if changed {
+			logger.Debug().Msg("Polling detected acme.json change")
+			w.readFile(logger)
+		}
+	}
 }
 
 func (w *Watcher) readFile(parentLogger *zerolog.Logger) {
+	w.readLock.Lock()
+	defer w.readLock.Unlock()
+
 	logger := parentLogger.With().Str("acme_path", w.AcmePath).Logger()
 	logger.Info().Msg("Reading acme.json file")
+
 	content, err := ioutil.ReadFile(w.AcmePath)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error reading acme.json file")
+		w.recordRead(err, nil)
+		return
+	}
+
+	sum := sha256.Sum256(content)
+	w.readSum = sum
+	if sum == w.sum {
+		logger.Debug().Msg("Skipping acme.json file as its contents didn't change")
+		w.recordRead(nil, nil)
 		return
 	}
 
@@ -81,9 +190,13 @@ func (w *Watcher) readFile(parentLogger *zerolog.Logger) {
 	err = json.Unmarshal(content, &acme)
 	if err != nil {
 		logger.Error().Err(err).Msg("Error parsing acme.json file as JSON")
+		w.recordRead(nil, err)
 		return
 	}
 
+	w.sum = sum
+
+	current := map[string]providedCertificate{}
 	for providerName, provider := range acme {
 		providerLogger := logger.With().Str("acme_provider", providerName).Logger()
 		for _, certificate := range provider.Certificates {
@@ -98,15 +211,19 @@ func (w *Watcher) readFile(parentLogger *zerolog.Logger) {
 				domains = append(domains, certificate.Domain.Sans...)
 			}
 
+			names := providerName + "|" + strings.Join(domains, ",")
+
 			decodedCert, err := base64.StdEncoding.DecodeString(certificate.Certificate)
 			if err != nil {
 				certificateLogger.Error().Err(err).Msg("Error decoding crt")
+				w.keepPrevious(current, names)
 				continue
 			}
 
 			decodedKey, err := base64.StdEncoding.DecodeString(certificate.Key)
 			if err != nil {
 				certificateLogger.Error().Err(err).Msg("Error decoding key")
+				w.keepPrevious(current, names)
 				continue
 			}
 
@@ -116,10 +233,53 @@ func (w *Watcher) readFile(parentLogger *zerolog.Logger) {
 				Key:   decodedKey,
 			}
 
+			// The key type is part of the key, so the certificate of the
+			// previous key type is removed when a resolver switches.
+			keyType := ""
+			if leaf, err := certFile.Leaf(); err == nil {
+				keyType = cert.KeyType(leaf)
+			}
+			key := names + "|" + keyType
+
 			w.certificateChannel <- watcher.Message{
 				MonitorName: "traefik",
+				Provider:    providerName,
+				Action:      watcher.UpdateCertificate,
 				Certificate: certFile,
 			}
+
+			current[key] = providedCertificate{provider: providerName, certificate: certFile}
+		}
+	}
+
+	for key, provided := range w.previous {
+		if _, ok := current[key]; ok {
+			continue
+		}
+
+		logger.Info().
+			Str("acme_provider", provided.provider).
+			Strs("names", provided.certificate.Names).
+			Msg("Certificate removed from acme.json file")
+
+		w.certificateChannel <- watcher.Message{
+			MonitorName: "traefik",
+			Provider:    provided.provider,
+			Action:      watcher.RemoveCertificate,
+			Certificate: provided.certificate,
+		}
+	}
+
+	w.previous = current
+	w.recordRead(nil, nil)
+}
+
+// keepPrevious keeps the previously read certificates for the provider and
+// names, so a certificate which fails decoding isn't considered removed.
+func (w *Watcher) keepPrevious(current map[string]providedCertificate, names string) {
+	for key, previous := range w.previous {
+		if strings.HasPrefix(key, names+"|") {
+			current[key] = previous
 		}
 	}
 }
@@ -0,0 +1,175 @@
+package traefik
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/RobertMe/cert-watcher/pkg/cert"
+	"github.com/RobertMe/cert-watcher/pkg/watcher"
+	"github.com/rs/zerolog"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAcme = `{
+	"default": {
+		"Certificates": [
+			{
+				"domain": {"main": "a.example.com"},
+				"certificate": "Y2VydA==",
+				"key": "a2V5"
+			}
+		]
+	}
+}`
+
+func TestWatchReturnsWhenWatcherCloses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acme.json")
+	if err := ioutil.WriteFile(path, []byte(testAcme), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &Watcher{AcmePath: path}
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages := make(chan watcher.Message)
+	result := make(chan error, 1)
+	go func() {
+		result <- w.Watch(messages, ctx)
+	}()
+
+	select {
+	case <-messages:
+	case <-time.After(time.Second):
+		t.Fatal("acme.json wasn't read")
+	}
+
+	w.watcher.Close()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("expected an error when the fsnotify watcher closes")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Watch didn't return after the fsnotify watcher closed")
+	}
+}
+
+// writeAcme writes an acme.json file holding a certificate for a.example.com
+// with a key of the algorithm, and returns the certificate.
+func writeAcme(t *testing.T, path string, algorithm string) []byte {
+	t.Helper()
+
+	key, err := cert.GenerateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "a.example.com"},
+		DNSNames:     []string{"a.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keyPem, err := cert.EncodeKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := fmt.Sprintf(`{"default": {"Certificates": [{"domain": {"main": "a.example.com"}, "certificate": %q, "key": %q}]}}`,
+		base64.StdEncoding.EncodeToString(certificate), base64.StdEncoding.EncodeToString(keyPem))
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate
+}
+
+func TestReadFileKeyTypeSwitch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acme.json")
+	messages := make(chan watcher.Message, 10)
+	w := &Watcher{AcmePath: path, certificateChannel: messages}
+	logger := zerolog.Nop()
+
+	rsa := writeAcme(t, path, "rsa2048")
+	w.readFile(&logger)
+	if message := <-messages; message.Action != watcher.UpdateCertificate || string(message.Certificate.Cert) != string(rsa) {
+		t.Fatalf("expected the RSA certificate to be updated, got %s", message.Action)
+	}
+
+	ecdsa := writeAcme(t, path, "ec256")
+	w.readFile(&logger)
+	if message := <-messages; message.Action != watcher.UpdateCertificate || string(message.Certificate.Cert) != string(ecdsa) {
+		t.Fatalf("expected the ECDSA certificate to be updated, got %s", message.Action)
+	}
+	if message := <-messages; message.Action != watcher.RemoveCertificate || string(message.Certificate.Cert) != string(rsa) {
+		t.Fatalf("expected the RSA certificate to be removed, got %s", message.Action)
+	}
+
+	if len(messages) != 0 {
+		t.Errorf("%d unexpected messages", len(messages))
+	}
+}
+
+func TestPollDetectsChangeWithSameModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acme.json")
+	if err := ioutil.WriteFile(path, []byte(testAcme), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make(chan watcher.Message, 10)
+	w := &Watcher{AcmePath: path, PollInterval: 10 * time.Millisecond, certificateChannel: messages}
+	logger := zerolog.Nop()
+	w.readFile(&logger)
+	<-messages
+
+	// Same size and modification time, only the contents differ.
+	changed := strings.Replace(testAcme, "Y2VydA==", "Y2VyZA==", 1)
+	if err := ioutil.WriteFile(path, []byte(changed), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.poll(ctx, &logger)
+
+	select {
+	case message := <-messages:
+		if string(message.Certificate.Cert) != "cerd" {
+			t.Errorf("unexpected certificate %q", message.Certificate.Cert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("polling didn't detect the change")
+	}
+}
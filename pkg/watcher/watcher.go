@@ -5,12 +5,38 @@ import (
 	"github.com/RobertMe/cert-watcher/pkg/cert"
 )
 
+const (
+	UpdateCertificate = "update_certificate"
+	RemoveCertificate = "remove_certificate"
+	ServedCertificate = "served_certificate"
+)
+
+// Message is sent by watchers for every certificate they find. Messages with
+// the RemoveCertificate action withdraw a previously sent certificate, for
+// example because it was removed or revoked. Messages with the
+// ServedCertificate action describe the certificate an endpoint serves, these
+// contain no key and are only used to verify deliveries. Provider identifies
+// the origin of the certificate within the watcher, e.g. the resolver in an
+// acme.json file, so certificates of different providers for the same names
+// are tracked separately.
 type Message struct {
 	MonitorName string
+	Provider    string
+	Action      string
+	Endpoint    string
 	Certificate cert.Certificate
 }
 
+// Watcher sends the certificates it finds to the certificate channel. Watch
+// blocks until the context is done, an error is returned when the watcher
+// can't continue.
 type Watcher interface {
 	Init() error
 	Watch(certificateChannel chan<- Message, parentCtx context.Context) error
 }
+
+// DomainListener is implemented by watchers which are able to obtain
+// certificates for the domains requested by subscribers.
+type DomainListener interface {
+	DomainsRequested(domains []string)
+}